@@ -0,0 +1,380 @@
+// Package analysis finds tokens held by holders of a token and whales among these holders.
+package analysis
+
+import (
+	apiclient "aper/api-client"
+	"aper/config"
+	"aper/screening"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Options are parameters of an analysis.
+type Options struct {
+	TokenAddress       string
+	TokenChain         apiclient.Chain
+	Chains             []string // chains holders' balances are retrieved on
+	MinTokenQnt        int      // holders with less tokens are not analysed
+	MinHoldingUSDValue decimal.Decimal
+	WhaleThreshold     decimal.Decimal // portfolio value across all chains
+	Date               time.Time       // holders and balances are retrieved as of that date, zero means latest
+	MaxHolders         int             // 0 means all
+	Concurrency        int             // number of holders processed at once
+	Rules              config.Rules
+}
+
+// Dependencies are used by the analyzer to retrieve data and report progress.
+// Only APIClient and Metadata are required.
+type Dependencies struct {
+	APIClient apiclient.APIClienter
+	Metadata  apiclient.TokenMetadataProvider
+	// OpenJournal is called once holders are retrieved, before any of them is processed
+	OpenJournal func(start Start) (Journal, error)
+	// Progress is called whenever the analysis moves to another stage and after every processed holder
+	Progress func(p Progress)
+	// Log is called with progress messages and errors the analysis continues after, nothing is logged when nil
+	Log func(format string, args ...interface{})
+}
+
+// Start describes an analysis whose holders are about to be processed.
+type Start struct {
+	TokenSymbol string
+	Blocks      map[apiclient.Chain]int // chain to block height, empty when analysing the latest blocks
+	Holders     []class_a.Portfolio     // as retrieved, including holders skipped for holding too few tokens
+}
+
+// Journal records results of processed holders, so that an interrupted analysis can be resumed.
+type Journal interface {
+	// Processed returns results of holders processed before, these holders are not processed again.
+	Processed() ([]*HolderResult, error)
+	Append(result *HolderResult) error
+}
+
+type Stage string
+
+const (
+	StageBlocks     Stage = "blocks"
+	StageTokensList Stage = "tokensList"
+	StageHolders    Stage = "holders"
+	StageBalances   Stage = "balances"
+	StageDone       Stage = "done"
+)
+
+type Progress struct {
+	Stage     Stage
+	Processed int // holders processed during the balances stage
+	Total     int
+}
+
+// Result of an analysis. Tokens are sorted by value and whales by portfolio value, in descending order.
+type Result struct {
+	TokenSymbol     string
+	Blocks          map[apiclient.Chain]int
+	AnalysedHolders int
+	Tokens          map[string][]Token // chain to tokens passing the screening rules
+	SkippedTokens   map[string][]Token // chain to tokens excluded by a rule
+	Whales          []Whale
+	Interrupted     bool // the context was done before all holders were processed
+}
+
+// Token is a token held by analysed holders on a chain, aggregated across these holders.
+type Token struct {
+	Symbol        string
+	Address       string // lowercase
+	Chain         string
+	Quantity      apiclient.TokenAmount
+	Value         decimal.Decimal
+	Rule          string // rule the token was excluded by
+	HolderMetrics HolderMetrics
+	Info          *apiclient.TokenInfo    // nil when the token is not on coingecko
+	PriceMetrics  *screening.PriceMetrics // nil when the price chart was not retrieved
+}
+
+// Whale is a holder whose portfolio value reaches the whale threshold.
+type Whale struct {
+	Address        string
+	PortfolioValue decimal.Decimal            // across all chains
+	ChainsValues   map[string]decimal.Decimal // chain to portfolio value on that chain
+	Holdings       []WhaleHolding             // sorted by value
+}
+
+// WhaleHolding is a token held by a whale, worth at least the min holding value.
+type WhaleHolding struct {
+	Chain    string
+	Symbol   string
+	Address  string
+	Quantity apiclient.TokenAmount
+	Value    decimal.Decimal
+	Rule     string // rule the token was excluded by
+}
+
+type Analyzer struct {
+	opts   Options
+	deps   Dependencies
+	engine *screening.Engine
+}
+
+func NewAnalyzer(opts Options, deps Dependencies) (*Analyzer, error) {
+	if deps.APIClient == nil || deps.Metadata == nil {
+		return nil, errors.New("API client and metadata provider are required")
+	}
+	engine, err := screening.NewEngine(opts.Rules)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure initializing screening rules")
+	}
+	return &Analyzer{
+		opts:   opts,
+		deps:   deps,
+		engine: engine,
+	}, nil
+}
+
+// run is the state of a single analysis, so that an analyzer can run several times.
+type run struct {
+	*Analyzer
+	blocks           map[apiclient.Chain]*int
+	coins            coins
+	holdingsPerChain map[string]holdings
+	whales           whales
+	analysedHolders  int64
+}
+
+// Run analyses holders of the token. When ctx is done while holders are processed,
+// the result of the holders processed so far is returned and marked as interrupted.
+func (a *Analyzer) Run(ctx context.Context) (*Result, error) {
+	r := &run{
+		Analyzer: a,
+		blocks:   make(map[apiclient.Chain]*int),
+		coins: coins{
+			lock:                &sync.RWMutex{},
+			coingeckoTokensMap:  make(map[apiclient.Chain]map[string]*apiclient.TokenInfo),
+			coingeckoSymbolsMap: make(map[apiclient.Chain]map[string]*apiclient.TokenInfo),
+			priceCharts:         make(map[string]*priceChart),
+		},
+		holdingsPerChain: make(map[string]holdings, len(a.opts.Chains)),
+		whales: whales{
+			lock: &sync.RWMutex{},
+			list: make(map[string]*whale, 0),
+		},
+	}
+	for _, chain := range a.opts.Chains {
+		r.holdingsPerChain[chain] = holdings{
+			lock:    &sync.RWMutex{},
+			list:    make(map[string]*holding, 0),
+			skipped: make(map[string]*holding, 0),
+		}
+	}
+
+	if !a.opts.Date.IsZero() {
+		a.progress(Progress{Stage: StageBlocks})
+		if err := r.retrieveBlocks(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	a.progress(Progress{Stage: StageTokensList})
+	if err := r.initCoingeckoTokensMap(ctx); err != nil {
+		return nil, err
+	}
+
+	a.progress(Progress{Stage: StageHolders})
+	a.logf("Retrieving holders...\n")
+	holders, err := a.deps.APIClient.GetTokenHolders(ctx, apiclient.GetTokenHoldersReq{
+		Chain:        a.opts.TokenChain,
+		TokenAddress: a.opts.TokenAddress,
+		Block:        r.blocks[a.opts.TokenChain],
+		MaxHolders:   a.opts.MaxHolders,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure retrieving token holders for address %s", a.opts.TokenAddress)
+	}
+	a.logf("Found %d holders\n", len(holders))
+	if len(holders) == 0 {
+		return nil, errors.Errorf("no holders found for address %s", a.opts.TokenAddress)
+	}
+
+	result := &Result{
+		TokenSymbol: holders[0].ContractTickerSymbol,
+		Blocks:      make(map[apiclient.Chain]int, len(r.blocks)),
+	}
+	for chain, block := range r.blocks {
+		result.Blocks[chain] = *block
+	}
+
+	journal := Journal(nopJournal{})
+	if a.deps.OpenJournal != nil {
+		journal, err = a.deps.OpenJournal(Start{
+			TokenSymbol: result.TokenSymbol,
+			Blocks:      result.Blocks,
+			Holders:     holders,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failure opening journal")
+		}
+	}
+	processedBefore, err := journal.Processed()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure reading journal")
+	}
+	processed := make(map[string]bool, len(processedBefore))
+	for _, holderResult := range processedBefore {
+		r.addHolderResult(holderResult)
+		processed[holderResult.Address] = true
+		r.analysedHolders++
+	}
+	if len(processed) > 0 {
+		a.logf("Resuming run, %d holders already processed\n", len(processed))
+	}
+
+	holdersAddresses := make([]string, 0, len(holders))
+	for _, holder := range holders {
+		skip, err := ShouldSkipHolder(&holder, a.opts.MinTokenQnt)
+		if err != nil {
+			a.logf("%s\n", err)
+		}
+		if skip || processed[holder.Address] {
+			continue
+		}
+		holdersAddresses = append(holdersAddresses, holder.Address)
+	}
+
+	total := len(processed) + len(holdersAddresses)
+	a.progress(Progress{Stage: StageBalances, Processed: len(processed), Total: total})
+	a.logf("Processing %s chains...\n", strings.Join(a.opts.Chains, ", "))
+	RunWorkers(ctx, a.opts.Concurrency, holdersAddresses, func(holderAddress string) {
+		holderResult := r.processHolder(ctx, holderAddress)
+		if ctx.Err() != nil {
+			// holder's result is incomplete, it is processed again on resume
+			return
+		}
+		if err := journal.Append(holderResult); err != nil {
+			a.logf("error journaling result of address %v: %v\n", holderAddress, err)
+		}
+		r.addHolderResult(holderResult)
+		atomic.AddInt64(&r.analysedHolders, 1)
+	}, func(done int) {
+		a.progress(Progress{Stage: StageBalances, Processed: len(processed) + done, Total: total})
+	})
+
+	result.Interrupted = ctx.Err() != nil
+	result.AnalysedHolders = int(r.analysedHolders)
+	result.Tokens = make(map[string][]Token, len(a.opts.Chains))
+	result.SkippedTokens = make(map[string][]Token, len(a.opts.Chains))
+	for _, chain := range a.opts.Chains {
+		result.Tokens[chain] = r.tokens(chain, r.holdingsPerChain[chain].list, result.AnalysedHolders)
+		result.SkippedTokens[chain] = r.tokens(chain, r.holdingsPerChain[chain].skipped, result.AnalysedHolders)
+	}
+	result.Whales = r.whalesList()
+
+	a.progress(Progress{Stage: StageDone, Processed: result.AnalysedHolders, Total: total})
+	return result, nil
+}
+
+func (a *Analyzer) progress(p Progress) {
+	if a.deps.Progress != nil {
+		a.deps.Progress(p)
+	}
+}
+
+func (a *Analyzer) logf(format string, args ...interface{}) {
+	if a.deps.Log != nil {
+		a.deps.Log(format, args...)
+	}
+}
+
+// retrieveBlocks finds blocks of the date, holders and their balances are all retrieved
+// as of the same date, which means a different block height on every chain.
+func (r *run) retrieveBlocks(ctx context.Context) error {
+	chains := append([]string{string(r.opts.TokenChain)}, r.opts.Chains...)
+	for _, chain := range chains {
+		if _, ok := r.blocks[apiclient.Chain(chain)]; ok {
+			continue
+		}
+		block, err := r.deps.APIClient.GetBlockByDate(ctx, apiclient.GetBlockByDateReq{
+			Chain: apiclient.Chain(chain),
+			Date:  r.opts.Date,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failure retrieving block by date for chain %s", chain)
+		}
+		if block == nil {
+			return errors.Errorf("no block found for given date on chain %s", chain)
+		}
+		r.logf("%s block: %d\n", chain, *block)
+		r.blocks[apiclient.Chain(chain)] = block
+	}
+	return nil
+}
+
+// tokens returns the aggregated holdings of the chain sorted by value.
+func (r *run) tokens(chain string, list map[string]*holding, analysedHolders int) []Token {
+	r.coins.lock.RLock()
+	defer r.coins.lock.RUnlock()
+
+	tokens := make([]Token, 0, len(list))
+	for address, h := range list {
+		token := Token{
+			Symbol:        h.symbol,
+			Address:       address,
+			Chain:         chain,
+			Quantity:      h.quantity,
+			Value:         h.value,
+			Rule:          h.rule,
+			HolderMetrics: computeHolderMetrics(h.positions, analysedHolders),
+		}
+		if coinInfo, ok := r.coins.lookup(apiclient.Chain(chain), address, h.symbol); ok {
+			info := *coinInfo
+			token.Info = &info
+			if chart, ok := r.coins.priceCharts[info.ID]; ok {
+				token.PriceMetrics = chart.metrics
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Value.GreaterThan(tokens[j].Value)
+	})
+	return tokens
+}
+
+func (r *run) whalesList() []Whale {
+	r.whales.lock.RLock()
+	defer r.whales.lock.RUnlock()
+
+	list := make([]Whale, 0, len(r.whales.list))
+	for address, w := range r.whales.list {
+		holdings := append([]WhaleHolding(nil), w.holdings...)
+		sort.SliceStable(holdings, func(i, j int) bool {
+			return holdings[i].Value.GreaterThan(holdings[j].Value)
+		})
+		list = append(list, Whale{
+			Address:        address,
+			PortfolioValue: w.portfolioValue,
+			ChainsValues:   w.chainsValues,
+			Holdings:       holdings,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].PortfolioValue.GreaterThan(list[j].PortfolioValue)
+	})
+	return list
+}
+
+// nopJournal is used when the analysis is not journaled.
+type nopJournal struct{}
+
+func (nopJournal) Processed() ([]*HolderResult, error) {
+	return nil, nil
+}
+
+func (nopJournal) Append(*HolderResult) error {
+	return nil
+}
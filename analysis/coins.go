@@ -0,0 +1,195 @@
+package analysis
+
+import (
+	apiclient "aper/api-client"
+	"aper/screening"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ruleNotOnCoingecko = "notOnCoingecko"
+
+	// nativeTokenAddress is used by Covalent for chain native tokens, which have no contract address
+	nativeTokenAddress = "0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+)
+
+type coins struct {
+	lock                *sync.RWMutex
+	coingeckoTokensMap  map[apiclient.Chain]map[string]*apiclient.TokenInfo // chain to lowercase token contract address to token info
+	coingeckoSymbolsMap map[apiclient.Chain]map[string]*apiclient.TokenInfo // chain to token symbol to token info, for coins without contract address
+	priceCharts         map[string]*priceChart                              // coin ID to price chart metrics
+}
+
+// priceChart is fetched once per coin, outside of the coins lock, however many holders hold the coin.
+type priceChart struct {
+	once    sync.Once
+	metrics *screening.PriceMetrics // nil when the chart could not be retrieved or is too short
+}
+
+// lookup returns token info by contract address, falling back to symbol only for tokens without contract address.
+// Callers must hold the lock.
+func (c coins) lookup(chain apiclient.Chain, contractAddress, symbol string) (*apiclient.TokenInfo, bool) {
+	contractAddress = strings.ToLower(contractAddress)
+	if contractAddress != "" && contractAddress != nativeTokenAddress {
+		tokenInfo, ok := c.coingeckoTokensMap[chain][contractAddress]
+		return tokenInfo, ok
+	}
+	tokenInfo, ok := c.coingeckoSymbolsMap[chain][strings.ToLower(symbol)]
+	return tokenInfo, ok
+}
+
+func (r *run) initCoingeckoTokensMap(ctx context.Context) error {
+	r.logf("Initializing coingecko tokens map...\n")
+
+	coinsList, err := r.deps.Metadata.GetTokensList(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failure getting coins list")
+	}
+
+	coins := r.coins
+	coins.lock.Lock()
+	defer coins.lock.Unlock()
+
+	for _, chain := range r.opts.Chains {
+		coins.coingeckoTokensMap[apiclient.Chain(chain)] = make(map[string]*apiclient.TokenInfo)
+		coins.coingeckoSymbolsMap[apiclient.Chain(chain)] = make(map[string]*apiclient.TokenInfo)
+	}
+
+	for _, coin := range coinsList {
+		if coin.ID == "" || coin.Symbol == "" {
+			continue
+		}
+
+		if strings.Contains(coin.ID, "wormhole") {
+			continue
+		}
+
+		tokenInfo := &apiclient.TokenInfo{
+			ID:     coin.ID,
+			Symbol: coin.Symbol,
+		}
+
+		if _, ok := coins.coingeckoSymbolsMap[apiclient.ETH]; ok && !hasPlatforms(coin) {
+			coins.coingeckoSymbolsMap[apiclient.ETH][coin.Symbol] = tokenInfo
+			continue
+		}
+		for _, chain := range r.opts.Chains {
+			contractAddress, ok := coin.Platforms[apiclient.CoingeckoPlatforms[apiclient.Chain(chain)]]
+			if !ok {
+				continue
+			}
+			if contractAddress == "" {
+				coins.coingeckoSymbolsMap[apiclient.Chain(chain)][coin.Symbol] = tokenInfo
+				continue
+			}
+			coins.coingeckoTokensMap[apiclient.Chain(chain)][strings.ToLower(contractAddress)] = tokenInfo
+		}
+	}
+	var entries int
+	for chain, tokens := range coins.coingeckoTokensMap {
+		entries += len(tokens) + len(coins.coingeckoSymbolsMap[chain])
+	}
+	if entries == 0 {
+		return errors.New("empty coingecko tokens map")
+	}
+
+	var perChain strings.Builder
+	for k, v := range coins.coingeckoTokensMap {
+		fmt.Fprintf(&perChain, "%s : %d, ", k, len(v)+len(coins.coingeckoSymbolsMap[k]))
+	}
+	r.logf("Tokens per chain in coingecko: %s\n", perChain.String())
+	return nil
+}
+
+func hasPlatforms(coin apiclient.Coin) bool {
+	for platform := range coin.Platforms {
+		if platform != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSkipToken returns the name of the rule excluding the token or an empty string when it should be kept.
+func (r *run) shouldSkipToken(ctx context.Context, chain string, balance *class_a.Portfolio) (string, error) {
+	token, chart, rule, err := r.screenTokenInfo(ctx, chain, balance)
+	if err != nil || rule != "" || chart == nil {
+		return rule, err
+	}
+
+	// price chart is fetched only for tokens passing the other rules
+	token.Metrics = r.priceMetrics(ctx, token.Info.ID, chart)
+	return r.engine.Screen(token), nil
+}
+
+// screenTokenInfo screens the token by its coingecko info, retrieved on first call.
+// The returned token holds a copy of the info, so that it can be screened again once the lock is released.
+// No chart is returned for tokens kept by the allow list without coingecko info.
+func (r *run) screenTokenInfo(ctx context.Context, chain string, balance *class_a.Portfolio) (screening.Token, *priceChart, string, error) {
+	coins := r.coins
+	coins.lock.Lock()
+	defer coins.lock.Unlock()
+
+	listedRule, listed := r.engine.Listed(balance.ContractAddress)
+	if listed && listedRule != "" {
+		return screening.Token{}, nil, listedRule, nil
+	}
+
+	tokenSymbol := balance.ContractTickerSymbol
+	tokenInfo, ok := coins.lookup(apiclient.Chain(chain), balance.ContractAddress, tokenSymbol)
+	if !ok || tokenInfo.ID == "" {
+		if listed {
+			return screening.Token{}, nil, "", nil
+		}
+		return screening.Token{}, nil, ruleNotOnCoingecko, nil
+	}
+
+	if tokenInfo.MarketCap.Equals(decimal.Decimal{}) {
+		coinGeckoTokenInfo, err := r.deps.Metadata.GetTokenInfo(ctx, tokenInfo.ID)
+		if err != nil {
+			return screening.Token{}, nil, "", errors.Wrapf(err, "failure getting coin info for coin ID: %s", tokenInfo.ID)
+		}
+
+		// token info is shared between chains, update it in place
+		*tokenInfo = *coinGeckoTokenInfo
+
+		r.logf("symbol: %s, tokenInfo: %+v\n", tokenSymbol, tokenInfo)
+	}
+
+	info := *tokenInfo
+	token := screening.Token{
+		Chain:   apiclient.Chain(chain),
+		Address: balance.ContractAddress,
+		Info:    &info,
+	}
+	if rule := r.engine.Screen(token); rule != "" {
+		return token, nil, rule, nil
+	}
+
+	chart, ok := coins.priceCharts[info.ID]
+	if !ok {
+		chart = &priceChart{}
+		coins.priceCharts[info.ID] = chart
+	}
+	return token, chart, "", nil
+}
+
+// priceMetrics returns metrics of the coin's price chart, fetching the chart on first call.
+func (r *run) priceMetrics(ctx context.Context, coinID string, chart *priceChart) *screening.PriceMetrics {
+	chart.once.Do(func() {
+		points, err := r.deps.Metadata.GetMarketChart(ctx, coinID, screening.PriceChartDays)
+		if err != nil {
+			r.logf("error retrieving market chart for coin ID %s: %s\n", coinID, err)
+			return
+		}
+		chart.metrics = screening.ComputePriceMetrics(points)
+	})
+	return chart.metrics
+}
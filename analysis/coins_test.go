@@ -0,0 +1,144 @@
+package analysis
+
+import (
+	apiclient "aper/api-client"
+	"aper/config"
+	"aper/screening"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/shopspring/decimal"
+)
+
+// countingMetadata serves the coins, the same token info and a slow price chart, counting chart requests.
+type countingMetadata struct {
+	coins      []apiclient.Coin
+	chartCalls int64
+}
+
+func (m *countingMetadata) GetTokensList(ctx context.Context) ([]apiclient.Coin, error) {
+	return m.coins, nil
+}
+
+func (m *countingMetadata) GetTokenInfo(ctx context.Context, tokenID string) (*apiclient.TokenInfo, error) {
+	return &apiclient.TokenInfo{ID: tokenID, MarketCap: decimal.NewFromInt(1000000)}, nil
+}
+
+func (m *countingMetadata) GetMarketChart(ctx context.Context, tokenID string, days int) ([]apiclient.PricePoint, error) {
+	atomic.AddInt64(&m.chartCalls, 1)
+	time.Sleep(10 * time.Millisecond)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []apiclient.PricePoint{{Time: start, Price: 1}, {Time: start.AddDate(0, 0, 1), Price: 2}}, nil
+}
+
+func TestShouldSkipTokenFetchesChartOnce(t *testing.T) {
+	engine, err := screening.NewEngine(config.Rules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := &countingMetadata{}
+	r := &run{
+		Analyzer: &Analyzer{deps: Dependencies{Metadata: metadata}, engine: engine},
+		coins: coins{
+			lock: &sync.RWMutex{},
+			coingeckoTokensMap: map[apiclient.Chain]map[string]*apiclient.TokenInfo{
+				apiclient.ETH: {"0xf": {ID: "f-token", Symbol: "f"}},
+			},
+			coingeckoSymbolsMap: map[apiclient.Chain]map[string]*apiclient.TokenInfo{},
+			priceCharts:         make(map[string]*priceChart),
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rule, err := r.shouldSkipToken(context.Background(), string(apiclient.ETH), &class_a.Portfolio{ContractAddress: "0xF", ContractTickerSymbol: "F"})
+			if err != nil || rule != "" {
+				t.Errorf("got rule %q, error %v, want the token kept", rule, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := atomic.LoadInt64(&metadata.chartCalls); calls != 1 {
+		t.Errorf("got %d market chart requests, want 1", calls)
+	}
+	if chart := r.coins.priceCharts["f-token"]; chart == nil || chart.metrics == nil {
+		t.Errorf("got no price metrics of the coin")
+	}
+}
+
+func TestInitCoingeckoTokensMap(t *testing.T) {
+	tests := []struct {
+		desc    string
+		coins   []apiclient.Coin
+		wantErr bool
+	}{
+		{desc: "token on analysed chain", coins: []apiclient.Coin{{ID: "a", Symbol: "a", Platforms: map[string]string{"arbitrum-one": "0x1"}}}},
+		{desc: "native coin of analysed chain", coins: []apiclient.Coin{{ID: "b", Symbol: "b", Platforms: map[string]string{"arbitrum-one": ""}}}},
+		{desc: "tokens on other chains only", coins: []apiclient.Coin{{ID: "c", Symbol: "c", Platforms: map[string]string{"fantom": "0x1"}}}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &run{
+				Analyzer: &Analyzer{opts: Options{Chains: []string{string(apiclient.ARBITRUM)}}, deps: Dependencies{Metadata: &countingMetadata{coins: tc.coins}}},
+				coins: coins{
+					lock:                &sync.RWMutex{},
+					coingeckoTokensMap:  make(map[apiclient.Chain]map[string]*apiclient.TokenInfo),
+					coingeckoSymbolsMap: make(map[apiclient.Chain]map[string]*apiclient.TokenInfo),
+				},
+			}
+			err := r.initCoingeckoTokensMap(context.Background())
+			if tc.wantErr != (err != nil) {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestShouldSkipTokenNotOnCoingecko(t *testing.T) {
+	engine, err := screening.NewEngine(config.Rules{Allow: []string{"0xAAAA"}, Deny: []string{"0xDDDD", "0xF"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := &countingMetadata{}
+	r := &run{
+		Analyzer: &Analyzer{deps: Dependencies{Metadata: metadata}, engine: engine},
+		coins: coins{
+			lock: &sync.RWMutex{},
+			coingeckoTokensMap: map[apiclient.Chain]map[string]*apiclient.TokenInfo{
+				apiclient.ETH: {"0xf": {ID: "f-token", Symbol: "f"}},
+			},
+			coingeckoSymbolsMap: map[apiclient.Chain]map[string]*apiclient.TokenInfo{},
+			priceCharts:         make(map[string]*priceChart),
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		address  string
+		wantRule string
+	}{
+		{desc: "allowlisted token", address: "0xAaAa"},
+		{desc: "denied token", address: "0xdddd", wantRule: "deny"},
+		{desc: "denied token on coingecko", address: "0xF", wantRule: "deny"},
+		{desc: "token on neither list", address: "0x1", wantRule: ruleNotOnCoingecko},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			rule, err := r.shouldSkipToken(context.Background(), string(apiclient.ETH), &class_a.Portfolio{ContractAddress: tc.address, ContractTickerSymbol: "T"})
+			if err != nil || rule != tc.wantRule {
+				t.Errorf("got rule %q, error %v, want %q", rule, err, tc.wantRule)
+			}
+		})
+	}
+	if calls := atomic.LoadInt64(&metadata.chartCalls); calls != 0 {
+		t.Errorf("got %d market chart requests, want none", calls)
+	}
+}
@@ -0,0 +1,55 @@
+package analysis
+
+import (
+	"sort"
+)
+
+// HolderMetrics describe how a token is held among analysed holders.
+type HolderMetrics struct {
+	Holders        int
+	HoldersShare   float64 // percentage of analysed holders holding the token
+	MedianPosition float64 // USD value
+	MeanPosition   float64 // USD value
+	Gini           float64 // 0 when all positions are equal, close to 1 when one holder holds almost everything
+	HHI            float64 // Herfindahl-Hirschman index, sum of squared position shares, from 1/holders to 1
+}
+
+// computeHolderMetrics returns metrics of the positions held by holders of a token.
+func computeHolderMetrics(positions []float64, analysedHolders int) HolderMetrics {
+	m := HolderMetrics{
+		Holders: len(positions),
+	}
+	if len(positions) == 0 {
+		return m
+	}
+	if analysedHolders > 0 {
+		m.HoldersShare = float64(len(positions)) / float64(analysedHolders) * 100
+	}
+
+	sorted := append([]float64(nil), positions...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		m.MedianPosition = sorted[n/2]
+	} else {
+		m.MedianPosition = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	var total, weighted float64
+	for i, position := range sorted {
+		total += position
+		weighted += float64(i+1) * position
+	}
+	m.MeanPosition = total / float64(n)
+	if total <= 0 {
+		return m
+	}
+
+	m.Gini = 2*weighted/(float64(n)*total) - float64(n+1)/float64(n)
+	for _, position := range sorted {
+		share := position / total
+		m.HHI += share * share
+	}
+	return m
+}
@@ -0,0 +1,206 @@
+package analysis
+
+import (
+	apiclient "aper/api-client"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/shopspring/decimal"
+)
+
+const deadAddress = "0x000000000000000000000000000000000000dead"
+
+// HolderResult is the screened holdings and portfolio values of a holder.
+type HolderResult struct {
+	Address string                  `json:"address"`
+	Chains  map[string]*ChainResult `json:"chains"`
+}
+
+type ChainResult struct {
+	PortfolioValue decimal.Decimal     `json:"portfolio_value"`
+	Holdings       []HoldingResult     `json:"holdings"`
+	Balances       []class_a.Portfolio `json:"-"` // as retrieved, not journaled so that journals stay small
+}
+
+type HoldingResult struct {
+	ContractAddress string                `json:"contract_address"`
+	Symbol          string                `json:"symbol"`
+	Quantity        apiclient.TokenAmount `json:"quantity"`
+	Value           decimal.Decimal       `json:"value"`
+	Rule            string                `json:"rule,omitempty"`
+}
+
+type whales struct {
+	lock *sync.RWMutex
+	list map[string]*whale // address to whale
+}
+
+type whale struct {
+	portfolioValue decimal.Decimal            // across all chains
+	chainsValues   map[string]decimal.Decimal // chain to portfolio value on that chain
+	holdings       []WhaleHolding
+}
+
+type holdings struct {
+	lock    *sync.RWMutex
+	list    map[string]*holding // lowercase token contract address to holding
+	skipped map[string]*holding // lowercase token contract address to holding excluded by a rule
+}
+
+type holding struct {
+	symbol    string
+	quantity  apiclient.TokenAmount
+	value     decimal.Decimal // quote to be able to sort
+	rule      string          // rule the holding was excluded by
+	positions []float64       // USD value held by each holder of the token
+}
+
+// ShouldSkipHolder reports whether the holder holds less than minTokenQnt tokens or is the dead address.
+// Holders with an incorrect balance are skipped and the error describes the balance.
+func ShouldSkipHolder(holder *class_a.Portfolio, minTokenQnt int) (bool, error) {
+	if holder.Address == deadAddress {
+		return true, nil
+	}
+
+	holderBalance, err := apiclient.NewTokenAmount(holder.Balance, holder.ContractDecimals)
+	if err != nil {
+		return true, fmt.Errorf("got incorrect balance: %s", holder.Balance)
+	}
+
+	return holderBalance.LessThanUnits(decimal.NewFromInt(int64(minTokenQnt))), nil
+}
+
+// shouldSkipBalance reports whether the balance is dust or of the analysed token itself.
+// Covalent returns lowercase addresses whereas the token address may be checksummed.
+func (a *Analyzer) shouldSkipBalance(chain string, balance *class_a.Portfolio) bool {
+	if balance.Type == "dust" {
+		return true
+	}
+	return chain == string(a.opts.TokenChain) && strings.EqualFold(balance.ContractAddress, a.opts.TokenAddress)
+}
+
+// processHolder scans the holder's balances on every chain,
+// so that whales are found by their portfolio value across all chains.
+func (r *run) processHolder(ctx context.Context, holderAddress string) *HolderResult {
+	result := &HolderResult{
+		Address: holderAddress,
+		Chains:  make(map[string]*ChainResult, len(r.opts.Chains)),
+	}
+	for _, chain := range r.opts.Chains {
+		chainResult, err := r.processHolderOnChain(ctx, holderAddress, chain)
+		if ctx.Err() != nil {
+			// the result is discarded, see Run
+			return result
+		}
+		if err != nil {
+			r.logf("error retrieving balances for chain %v, address: %v; %v\n", chain, holderAddress, err)
+			continue
+		}
+		result.Chains[chain] = chainResult
+	}
+	return result
+}
+
+// processHolderOnChain returns the holder's screened holdings and portfolio value on the chain.
+func (r *run) processHolderOnChain(ctx context.Context, holderAddress, chain string) (*ChainResult, error) {
+	balances, err := r.deps.APIClient.GetAddressBalances(ctx, apiclient.GetAddressBalancesReq{
+		Chain:   apiclient.Chain(chain),
+		Address: holderAddress,
+		Block:   r.blocks[apiclient.Chain(chain)],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ChainResult{
+		PortfolioValue: decimal.NewFromInt(0),
+		Balances:       balances,
+	}
+	for _, balance := range balances {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		quote := decimal.NewFromFloat(balance.Quote)
+		result.PortfolioValue = result.PortfolioValue.Add(quote)
+
+		if r.shouldSkipBalance(chain, &balance) {
+			continue
+		}
+		if !r.opts.MinHoldingUSDValue.LessThanOrEqual(quote) {
+			continue
+		}
+		rule, err := r.shouldSkipToken(ctx, chain, &balance)
+		if err != nil {
+			r.logf("error checking for token skip: %s\n", err)
+			continue
+		}
+
+		quantity, err := apiclient.NewTokenAmount(balance.Balance, balance.ContractDecimals)
+		if err != nil {
+			r.logf("got incorrect balance %s of %s\n", balance.Balance, balance.ContractTickerSymbol)
+			continue
+		}
+
+		result.Holdings = append(result.Holdings, HoldingResult{
+			ContractAddress: strings.ToLower(balance.ContractAddress),
+			Symbol:          balance.ContractTickerSymbol,
+			Quantity:        quantity,
+			Value:           quote,
+			Rule:            rule,
+		})
+	}
+	return result, nil
+}
+
+// addHolderResult aggregates the holder's result into the chains holdings and the whales list.
+func (r *run) addHolderResult(result *HolderResult) {
+	holderWhale := &whale{
+		portfolioValue: decimal.NewFromInt(0),
+		chainsValues:   make(map[string]decimal.Decimal, len(result.Chains)),
+	}
+	for chain, chainResult := range result.Chains {
+		holderWhale.chainsValues[chain] = chainResult.PortfolioValue
+		holderWhale.portfolioValue = holderWhale.portfolioValue.Add(chainResult.PortfolioValue)
+
+		holdings, ok := r.holdingsPerChain[chain]
+		if !ok {
+			continue
+		}
+		for _, h := range chainResult.Holdings {
+			holderWhale.holdings = append(holderWhale.holdings, WhaleHolding{
+				Chain:    chain,
+				Symbol:   h.Symbol,
+				Address:  h.ContractAddress,
+				Quantity: h.Quantity,
+				Value:    h.Value,
+				Rule:     h.Rule,
+			})
+		}
+
+		holdings.lock.Lock()
+		for _, h := range chainResult.Holdings {
+			list := holdings.list
+			if h.Rule != "" {
+				list = holdings.skipped
+			}
+			if v, ok := list[h.ContractAddress]; ok {
+				v.quantity = v.quantity.Add(h.Quantity)
+				v.value = v.value.Add(h.Value)
+				v.positions = append(v.positions, h.Value.InexactFloat64())
+			} else {
+				list[h.ContractAddress] = &holding{symbol: h.Symbol, quantity: h.Quantity, value: h.Value, rule: h.Rule,
+					positions: []float64{h.Value.InexactFloat64()}}
+			}
+		}
+		holdings.lock.Unlock()
+	}
+
+	if !holderWhale.portfolioValue.LessThan(r.opts.WhaleThreshold) {
+		r.whales.lock.Lock()
+		r.whales.list[result.Address] = holderWhale
+		r.whales.lock.Unlock()
+	}
+}
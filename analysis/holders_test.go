@@ -0,0 +1,94 @@
+package analysis
+
+import (
+	apiclient "aper/api-client"
+	"testing"
+
+	"github.com/cshields143/govalent/class_a"
+)
+
+func TestShouldSkipHolder(t *testing.T) {
+	tests := []struct {
+		desc    string
+		holder  class_a.Portfolio
+		want    bool
+		wantErr bool
+	}{
+		{
+			desc:   "18 decimals above minimum",
+			holder: class_a.Portfolio{Address: "0x1", Balance: "150000000000000000000", ContractDecimals: 18},
+			want:   false,
+		},
+		{
+			desc:   "18 decimals below minimum",
+			holder: class_a.Portfolio{Address: "0x1", Balance: "99000000000000000000", ContractDecimals: 18},
+			want:   true,
+		},
+		{
+			desc:   "18 decimals exactly minimum",
+			holder: class_a.Portfolio{Address: "0x1", Balance: "100000000000000000000", ContractDecimals: 18},
+			want:   false,
+		},
+		{
+			desc:   "6 decimals below minimum",
+			holder: class_a.Portfolio{Address: "0x1", Balance: "99999999", ContractDecimals: 6},
+			want:   true,
+		},
+		{
+			desc:   "0 decimals above minimum",
+			holder: class_a.Portfolio{Address: "0x1", Balance: "101", ContractDecimals: 0},
+			want:   false,
+		},
+		{
+			desc:   "dead address",
+			holder: class_a.Portfolio{Address: "0x000000000000000000000000000000000000dead", Balance: "150000000000000000000", ContractDecimals: 18},
+			want:   true,
+		},
+		{
+			desc:    "incorrect balance",
+			holder:  class_a.Portfolio{Address: "0x1", Balance: "n/a", ContractDecimals: 18},
+			want:    true,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := ShouldSkipHolder(&tc.holder, 100)
+			if got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if tc.wantErr != (err != nil) {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestShouldSkipBalance(t *testing.T) {
+	a := &Analyzer{opts: Options{TokenAddress: "0xAbCdEf1111111111111111111111111111111111", TokenChain: apiclient.ETH}}
+
+	tests := []struct {
+		desc    string
+		chain   apiclient.Chain
+		balance class_a.Portfolio
+		want    bool
+	}{
+		{desc: "analysed token with lowercase address", chain: apiclient.ETH,
+			balance: class_a.Portfolio{ContractAddress: "0xabcdef1111111111111111111111111111111111"}, want: true},
+		{desc: "analysed token with same address", chain: apiclient.ETH,
+			balance: class_a.Portfolio{ContractAddress: "0xAbCdEf1111111111111111111111111111111111"}, want: true},
+		{desc: "same address on another chain", chain: apiclient.ARBITRUM,
+			balance: class_a.Portfolio{ContractAddress: "0xabcdef1111111111111111111111111111111111"}},
+		{desc: "other token", chain: apiclient.ETH,
+			balance: class_a.Portfolio{ContractAddress: "0x2222222222222222222222222222222222222222"}},
+		{desc: "dust", chain: apiclient.ARBITRUM,
+			balance: class_a.Portfolio{ContractAddress: "0x2222222222222222222222222222222222222222", Type: "dust"}, want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := a.shouldSkipBalance(string(tc.chain), &tc.balance); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
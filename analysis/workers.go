@@ -0,0 +1,45 @@
+package analysis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// RunWorkers calls process for every address with at most concurrency calls running at once,
+// and done with the number of addresses processed so far after every call.
+// Once ctx is done no more addresses are processed and RunWorkers returns after running calls finish.
+func RunWorkers(ctx context.Context, concurrency int, addresses []string, process func(address string), done func(processed int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var processed int64
+
+	addressesCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for address := range addressesCh {
+				process(address)
+				n := atomic.AddInt64(&processed, 1)
+				if done != nil {
+					done(int(n))
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, address := range addresses {
+		select {
+		case <-ctx.Done():
+			break feed
+		case addressesCh <- address:
+		}
+	}
+	close(addressesCh)
+	wg.Wait()
+}
@@ -13,19 +13,29 @@ import (
 
 	"github.com/cshields143/govalent"
 	"github.com/cshields143/govalent/class_a"
-	"golang.org/x/time/rate"
+	"github.com/cshields143/govalent/client"
 )
 
 type APIClienter interface {
-	GetTokenHolders(chain Chain, token string, block *int) ([]class_a.Portfolio, error)
-	GetAddressBalances(req GetAddressBalancesReq) ([]class_a.Portfolio, error)
-	GetAddressBalancesRateLimited(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error)
-	GetBlockByDate(req GetBlockByDateReq) (*int, error)
+	GetTokenHolders(ctx context.Context, req GetTokenHoldersReq) ([]class_a.Portfolio, error)
+	GetAddressBalances(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error)
+	GetBlockByDate(ctx context.Context, req GetBlockByDateReq) (*int, error)
+	GetAddressTransactions(ctx context.Context, req GetAddressTransactionsReq) ([]class_a.Transaction, error)
+}
+
+type GetTokenHoldersReq struct {
+	Chain        Chain
+	TokenAddress string
+	Block        *int
+	// MaxHolders stops pagination once that many holders are retrieved; 0 means no limit.
+	MaxHolders int
 }
 
 type GetAddressBalancesReq struct {
 	Chain   Chain
 	Address string
+	// Block makes the balances historical, as of the given block height; nil means current balances.
+	Block *int
 }
 
 type GetBlockByDateReq struct {
@@ -33,104 +43,220 @@ type GetBlockByDateReq struct {
 	Date  time.Time
 }
 
-func (c *ApiClient) GetAddressBalancesRateLimited(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error) {
-	if err := c.balancesReqsLimiter.Wait(ctx); err != nil {
-		return nil, err
-	}
-	return c.GetAddressBalances(req)
+type GetAddressTransactionsReq struct {
+	Chain   Chain
+	Address string
+	Since   time.Time
 }
 
+const DefaultMoralisURL = "https://deep-index.moralis.io/api/v2"
+
 type ApiClient struct {
-	cfg                 config.Config
-	balancesReqsLimiter *rate.Limiter
+	cfg             config.Config
+	moralisURL      string
+	covalentLimiter *AdaptiveLimiter
+	moralisLimiter  *AdaptiveLimiter
+	retryPolicy     RetryPolicy
 }
 
-func NewAPIClient(cfg *config.Config) APIClienter {
+func NewAPIClient(cfg *config.Config, limiters *Limiters) APIClienter {
 	govalent.APIKey = cfg.ApiKey
-	return &ApiClient{
-		cfg:                 *cfg,
-		balancesReqsLimiter: rate.NewLimiter(rate.Every(time.Millisecond*50), 1),
+	c := &ApiClient{
+		cfg:             *cfg,
+		moralisURL:      DefaultMoralisURL,
+		covalentLimiter: limiters.Covalent,
+		moralisLimiter:  limiters.Moralis,
+		retryPolicy:     DefaultRetryPolicy,
+	}
+	if cfg.MoralisURL != "" {
+		c.moralisURL = strings.TrimSuffix(cfg.MoralisURL, "/")
 	}
+	return c
 }
 
-func (c *ApiClient) GetTokenHolders(chain Chain, tokenAddress string, block *int) ([]class_a.Portfolio, error) {
-	chainID, ok := Chains[chain]
+func (c *ApiClient) GetTokenHolders(ctx context.Context, req GetTokenHoldersReq) ([]class_a.Portfolio, error) {
+	chainID, ok := Chains[req.Chain]
 	if !ok {
-		return nil, errors.New("not supported chain")
+		return nil, ErrUnsupportedChain
 	}
 
 	params := class_a.TokenHoldersWithHeightParams{
 		PageSize: 500,
 	}
-	if block != nil {
-		params.BlockHeight = fmt.Sprint(*block)
+	if req.Block != nil {
+		params.BlockHeight = fmt.Sprint(*req.Block)
 	}
 
-retry:
-	portfolios, err := govalent.ClassA().TokenHolders(chainID, tokenAddress, params)
-	if err != nil {
-		if isAPITempError(err) {
-			goto retry
+	var holders []class_a.Portfolio
+	for {
+		var portfolios class_a.TokenHolders
+		err := c.covalentRequest(ctx, "holders", func() (err error) {
+			portfolios, err = govalent.ClassA().TokenHolders(chainID, req.TokenAddress, params)
+			return err
+		})
+		if err != nil {
+			return nil, err
 		}
+
+		holders = append(holders, portfolios.Items...)
+		if req.MaxHolders > 0 && len(holders) >= req.MaxHolders {
+			return holders[:req.MaxHolders], nil
+		}
+		if !portfolios.Pagination.HasMore {
+			return holders, nil
+		}
+
+		if portfolios.Pagination.TotalCount > 0 {
+			fmt.Printf("Retrieved %d/%d holders (%s)...\n", len(holders), portfolios.Pagination.TotalCount, c.covalentLimiter)
+		} else {
+			fmt.Printf("Retrieved %d holders (%s)...\n", len(holders), c.covalentLimiter)
+		}
+		params.PageNumber++
+	}
+}
+
+func (c *ApiClient) GetAddressBalances(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error) {
+	chainID, ok := Chains[req.Chain]
+	if !ok {
+		return nil, ErrUnsupportedChain
+	}
+
+	var portfolios class_a.Portfolios
+	err := c.covalentRequest(ctx, "balances", func() (err error) {
+		portfolios, err = c.getAddressBalances(chainID, req)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return portfolios.Items, nil
 }
 
-func (c *ApiClient) GetAddressBalances(req GetAddressBalancesReq) ([]class_a.Portfolio, error) {
+// GetAddressTransactions returns transactions of the address signed at or after req.Since.
+// Covalent returns the newest transactions first, so pages are fetched until an older one shows up.
+func (c *ApiClient) GetAddressTransactions(ctx context.Context, req GetAddressTransactionsReq) ([]class_a.Transaction, error) {
 	chainID, ok := Chains[req.Chain]
 	if !ok {
-		return nil, errors.New("not supported chain")
+		return nil, ErrUnsupportedChain
 	}
 
-retry:
-	if err := c.balancesReqsLimiter.Wait(context.Background()); err != nil {
-		return nil, err
+	api := client.New(govalent.APIURL, c.cfg.ApiKey, http.DefaultClient)
+	params := transactionsParams{
+		PageSize: 100,
 	}
-	portfolios, err := govalent.ClassA().TokenBalances(chainID, req.Address, class_a.BalanceParams{
-		Nft:        false,
-		NoNftFetch: false,
-	})
-	if err != nil {
-		if isAPITempError(err) || isRateLimitExceededError(err) {
-			fmt.Printf("error retrieving balances: %s, retrying...\n", err)
-			time.Sleep(time.Second / 2)
-			goto retry
+
+	var transactions []class_a.Transaction
+	for {
+		response := class_a.TransactionResponse{}
+		err := c.covalentRequest(ctx, "transactions", func() error {
+			return api.Request("GET", fmt.Sprintf("%v/address/%v/transactions_v2/", chainID, req.Address), params, &response)
+		})
+		if err != nil {
+			return nil, err
 		}
-		// if isRateLimitExceededError(err) {
-		// 	if c.balancesReqsLimiter.Limit() < rate.Limit(time.Millisecond*200) {
-		// 		c.balancesReqsLimiter.SetLimit(c.balancesReqsLimiter.Limit() * 2)
-		// 		fmt.Printf("Rate limit modified to %v\n", c.balancesReqsLimiter.Limit())
-		// 	}
-		// 	time.Sleep(time.Second / 2)
-		// 	goto retry
-		// }
-		return nil, err
+
+		for _, tx := range response.Data.Items {
+			if tx.BlockSignedAt.Before(req.Since) {
+				return transactions, nil
+			}
+			transactions = append(transactions, tx)
+		}
+
+		if !response.Data.Pagination.HasMore {
+			return transactions, nil
+		}
+		params.PageNumber++
 	}
+}
 
-	return portfolios.Items, nil
+func (c *ApiClient) getAddressBalances(chainID string, req GetAddressBalancesReq) (class_a.Portfolios, error) {
+	if req.Block == nil {
+		return govalent.ClassA().TokenBalances(chainID, req.Address, class_a.BalanceParams{
+			Nft:        false,
+			NoNftFetch: false,
+		})
+	}
+
+	api := client.New(govalent.APIURL, c.cfg.ApiKey, http.DefaultClient)
+	response := class_a.BalanceResponse{}
+	err := api.Request("GET", fmt.Sprintf("%v/address/%v/historical_balances/", chainID, req.Address), historicalBalancesParams{
+		BlockHeight: *req.Block,
+	}, &response)
+	return response.Data, err
 }
 
-func isRateLimitExceededError(err error) bool {
-	return err.Error() == "Rate limit exceeded"
+// covalentRequest sends the request through the covalent rate limiter, retrying it according
+// to the retry policy. Covalent errors carry no Retry-After, so on rate limiting the rate is only lowered.
+func (c *ApiClient) covalentRequest(ctx context.Context, what string, request func() error) error {
+	return c.retryPolicy.Do(ctx, what, func() error {
+		if err := c.covalentLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := covalentError(request())
+		switch {
+		case err == nil:
+			c.covalentLimiter.Success()
+		case errors.Is(err, ErrRateLimited):
+			c.covalentLimiter.RateLimited(0)
+		}
+		return err
+	})
 }
 
-func isAPITempError(err error) bool {
-	if err.Error() == "backend queue is full and cannot accept request" ||
-		err.Error() == "invalid character '<' looking for beginning of value" ||
-		err.Error() == "Database statement timeout exceeded" ||
-		strings.Contains(err.Error(), "timeout") {
-		return true
+// sleep waits for the given duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-	return false
 }
 
-func (c *ApiClient) GetBlockByDate(req GetBlockByDateReq) (*int, error) {
+// GetBlockByDate returns the block at the given date. Moralis is used when it supports the chain,
+// otherwise the block is found by binary search over Covalent block timestamps.
+func (c *ApiClient) GetBlockByDate(ctx context.Context, req GetBlockByDateReq) (*int, error) {
+	if _, ok := Chains[req.Chain]; !ok {
+		return nil, ErrUnsupportedChain
+	}
+
+	moralisChain, ok := MoralisChain[req.Chain]
+	if !ok || c.cfg.MoralisApiKey == "" {
+		return c.getBlockByDateFromCovalent(ctx, req)
+	}
+
 	date := req.Date.Format("2006-01-02")
-	url := fmt.Sprintf("https://deep-index.moralis.io/api/v2/dateToBlock?chain=%s&date=%s", MoralisChain[req.Chain], date)
+	url := fmt.Sprintf("%s/dateToBlock?chain=%s&date=%s", c.moralisURL, moralisChain, date)
+
+	var body []byte
+	err := c.retryPolicy.Do(ctx, "block by date", func() (err error) {
+		body, err = c.moralisGet(ctx, url)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var dateToBlock MoralisDateToBlockResponse
+	if err := json.Unmarshal(body, &dateToBlock); err != nil {
+		return nil, err
+	}
+	if dateToBlock.Block == nil {
+		return nil, fmt.Errorf("no block in moralis response: %s", string(body))
+	}
+
+	return dateToBlock.Block, nil
+}
 
-	r, err := http.NewRequest("GET", url, nil)
+// moralisGet sends the request through the moralis rate limiter.
+func (c *ApiClient) moralisGet(ctx context.Context, url string) ([]byte, error) {
+	if err := c.moralisLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -140,21 +266,74 @@ func (c *ApiClient) GetBlockByDate(req GetBlockByDateReq) (*int, error) {
 
 	res, err := http.DefaultClient.Do(r)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrTemporary, err)
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrTemporary, err)
 	}
 
-	bodyMap := make(map[string]interface{})
-	if err := json.Unmarshal(body, &bodyMap); err != nil {
+	if res.StatusCode == http.StatusTooManyRequests {
+		c.moralisLimiter.RateLimited(retryAfter(res.Header.Get("Retry-After"), time.Second))
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError("moralis", res.StatusCode, body)
+	}
+	c.moralisLimiter.Success()
+	return body, nil
+}
+
+// getBlockByDateFromCovalent returns the first block signed at or after the given date.
+func (c *ApiClient) getBlockByDateFromCovalent(ctx context.Context, req GetBlockByDateReq) (*int, error) {
+	chainID := Chains[req.Chain]
+
+	latest, err := c.getBlock(ctx, chainID, "latest")
+	if err != nil {
 		return nil, err
 	}
+	if latest.SignedAt.Before(req.Date) {
+		return nil, fmt.Errorf("date %s is after the latest block", req.Date.Format("2006-01-02"))
+	}
+
+	low, high := 0, latest.Height
+	for low < high {
+		middle := low + (high-low)/2
+		block, err := c.getBlock(ctx, chainID, fmt.Sprint(middle))
+		if err != nil {
+			return nil, err
+		}
+		if block.SignedAt.Before(req.Date) {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
 
-	block := int(bodyMap["block"].(float64))
+	return &low, nil
+}
+
+type covalentBlock struct {
+	Height   int
+	SignedAt time.Time
+}
+
+func (c *ApiClient) getBlock(ctx context.Context, chainID, height string) (*covalentBlock, error) {
+	var blocks class_a.Blocks
+	err := c.covalentRequest(ctx, "block", func() (err error) {
+		blocks, err = govalent.ClassA().Block(chainID, height)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks.Items) == 0 {
+		return nil, fmt.Errorf("block %s not found", height)
+	}
 
-	return &block, nil
+	return &covalentBlock{
+		Height:   blocks.Items[0].Height,
+		SignedAt: blocks.Items[0].SignedAt,
+	}, nil
 }
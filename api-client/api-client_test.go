@@ -0,0 +1,100 @@
+package apiclient
+
+import (
+	"aper/config"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newMoralisStandIn serves the date to block endpoint, responding with status codes from statuses
+// to consecutive requests and with the block afterwards.
+func newMoralisStandIn(t *testing.T, statuses ...int) *httptest.Server {
+	t.Helper()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { requests++ }()
+		if r.URL.Path != "/dateToBlock" || r.Header.Get("X-API-Key") != "moralis-key" {
+			http.NotFound(w, r)
+			return
+		}
+		if requests < len(statuses) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statuses[requests])
+			return
+		}
+		if r.URL.Query().Get("chain") != "eth" || r.URL.Query().Get("date") != "2023-06-23" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"block":17546000,"date":"2023-06-23T00:00:00+00:00","timestamp":1687478400}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAPIClient(moralisURL string) *ApiClient {
+	c := NewAPIClient(&config.Config{
+		MoralisApiKey: "moralis-key",
+		MoralisURL:    moralisURL,
+	}, NewLimiters(config.RateLimits{Moralis: 1000})).(*ApiClient)
+	c.retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return c
+}
+
+func TestGetBlockByDate(t *testing.T) {
+	date := time.Date(2023, 6, 23, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		chain     Chain
+		statuses  []int
+		wantBlock int
+		wantErr   error
+	}{
+		{
+			name:      "block from moralis",
+			chain:     ETH,
+			wantBlock: 17546000,
+		},
+		{
+			name:      "retried after rate limiting and server error",
+			chain:     ETH,
+			statuses:  []int{http.StatusTooManyRequests, http.StatusBadGateway},
+			wantBlock: 17546000,
+		},
+		{
+			name:     "rate limited until attempts run out",
+			chain:    ETH,
+			statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			wantErr:  ErrRateLimited,
+		},
+		{
+			name:    "unsupported chain",
+			chain:   Chain("SOLANA"),
+			wantErr: ErrUnsupportedChain,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestAPIClient(newMoralisStandIn(t, tt.statuses...).URL)
+
+			block, err := c.GetBlockByDate(context.Background(), GetBlockByDateReq{Chain: tt.chain, Date: date})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block == nil || *block != tt.wantBlock {
+				t.Errorf("got block %v, want %d", block, tt.wantBlock)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package apiclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+)
+
+// Cache stores API responses as JSON files on disk. Entries older than ttl are ignored.
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+func (c *Cache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+func (c *Cache) get(key string, out interface{}) bool {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
+
+func (c *Cache) set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that concurrent readers never see a partial entry
+	tmp, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+func cached[T any](c *Cache, key string, fetch func() (T, error)) (T, error) {
+	var value T
+	if c.get(key, &value) {
+		return value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	if err := c.set(key, value); err != nil {
+		fmt.Printf("error caching %s: %s\n", key, err)
+	}
+	return value, nil
+}
+
+type cachedAPIClient struct {
+	client APIClienter
+	cache  *Cache
+}
+
+// NewCachedAPIClient wraps client so that responses are served from cache when available.
+func NewCachedAPIClient(client APIClienter, cache *Cache) APIClienter {
+	return &cachedAPIClient{
+		client: client,
+		cache:  cache,
+	}
+}
+
+func (c *cachedAPIClient) GetTokenHolders(ctx context.Context, req GetTokenHoldersReq) ([]class_a.Portfolio, error) {
+	key := fmt.Sprintf("holders/%s/%s/%s/%d", req.Chain, req.TokenAddress, blockKey(req.Block), req.MaxHolders)
+	return cached(c.cache, key, func() ([]class_a.Portfolio, error) {
+		return c.client.GetTokenHolders(ctx, req)
+	})
+}
+
+func (c *cachedAPIClient) GetAddressBalances(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error) {
+	return cached(c.cache, balancesKey(req), func() ([]class_a.Portfolio, error) {
+		return c.client.GetAddressBalances(ctx, req)
+	})
+}
+
+func (c *cachedAPIClient) GetBlockByDate(ctx context.Context, req GetBlockByDateReq) (*int, error) {
+	key := fmt.Sprintf("block/%s/%s", req.Chain, req.Date.Format("2006-01-02"))
+	return cached(c.cache, key, func() (*int, error) {
+		return c.client.GetBlockByDate(ctx, req)
+	})
+}
+
+func (c *cachedAPIClient) GetAddressTransactions(ctx context.Context, req GetAddressTransactionsReq) ([]class_a.Transaction, error) {
+	key := fmt.Sprintf("transactions/%s/%s/%d", req.Chain, req.Address, req.Since.Unix())
+	return cached(c.cache, key, func() ([]class_a.Transaction, error) {
+		return c.client.GetAddressTransactions(ctx, req)
+	})
+}
+
+func balancesKey(req GetAddressBalancesReq) string {
+	return fmt.Sprintf("balances/%s/%s/%s", req.Chain, req.Address, blockKey(req.Block))
+}
+
+func blockKey(block *int) string {
+	if block == nil {
+		return "latest"
+	}
+	return fmt.Sprint(*block)
+}
+
+type cachedMetadataProvider struct {
+	provider TokenMetadataProvider
+	cache    *Cache
+}
+
+// NewCachedMetadataProvider wraps provider so that responses are served from cache when available.
+func NewCachedMetadataProvider(provider TokenMetadataProvider, cache *Cache) TokenMetadataProvider {
+	return &cachedMetadataProvider{
+		provider: provider,
+		cache:    cache,
+	}
+}
+
+func (p *cachedMetadataProvider) GetTokensList(ctx context.Context) ([]Coin, error) {
+	return cached(p.cache, "tokens-list", func() ([]Coin, error) {
+		return p.provider.GetTokensList(ctx)
+	})
+}
+
+func (p *cachedMetadataProvider) GetTokenInfo(ctx context.Context, tokenID string) (*TokenInfo, error) {
+	return cached(p.cache, "token-info/"+tokenID, func() (*TokenInfo, error) {
+		return p.provider.GetTokenInfo(ctx, tokenID)
+	})
+}
+
+func (p *cachedMetadataProvider) GetMarketChart(ctx context.Context, tokenID string, days int) ([]PricePoint, error) {
+	return cached(p.cache, "market-chart/"+chartKey(tokenID, days), func() ([]PricePoint, error) {
+		return p.provider.GetMarketChart(ctx, tokenID, days)
+	})
+}
@@ -12,9 +12,16 @@ type CoingeckoCoinInfo struct {
 }
 
 type CoingeckoCoinMarketData struct {
-	MarketCap CoingeckoCoinMarketCap `json:"market_cap"`
+	MarketCap             CoingeckoUSDValue `json:"market_cap"`
+	FullyDilutedValuation CoingeckoUSDValue `json:"fully_diluted_valuation"`
+	TotalVolume           CoingeckoUSDValue `json:"total_volume"`
+	ATHChangePercentage   CoingeckoUSDValue `json:"ath_change_percentage"`
 }
 
-type CoingeckoCoinMarketCap struct {
+type CoingeckoUSDValue struct {
 	USD decimal.Decimal `json:"usd"`
 }
+
+type CoingeckoMarketChart struct {
+	Prices [][]float64 `json:"prices"` // pairs of unix milliseconds and USD price
+}
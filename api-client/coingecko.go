@@ -0,0 +1,157 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCoingeckoURL = "https://api.coingecko.com/api/v3"
+
+	coingeckoCoinsListPath = "/coins/list?include_platform=true"
+	coingeckoCoinPath      = "/coins/%s?localization=false&tickers=false&community_data=false&developer_data=false&sparkline=false"
+
+	coingeckoMarketChartPath = "/coins/%s/market_chart?vs_currency=usd&interval=daily&days=%d"
+
+	coingeckoDefaultRetryAfter = time.Minute
+)
+
+type CoingeckoProvider struct {
+	baseURL     string
+	reqsLimiter *AdaptiveLimiter
+	retryPolicy RetryPolicy
+}
+
+// NewCoingeckoProvider returns the provider sending requests to baseURL, DefaultCoingeckoURL when empty.
+func NewCoingeckoProvider(baseURL string, limiter *AdaptiveLimiter) TokenMetadataProvider {
+	if baseURL == "" {
+		baseURL = DefaultCoingeckoURL
+	}
+	return &CoingeckoProvider{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		reqsLimiter: limiter,
+		retryPolicy: DefaultRetryPolicy,
+	}
+}
+
+func (p *CoingeckoProvider) GetTokensList(ctx context.Context) ([]Coin, error) {
+	body, err := p.get(ctx, coingeckoCoinsListPath)
+	if err != nil {
+		return nil, fmt.Errorf("failure retrieving coingecko coins list: %w", err)
+	}
+
+	var coinsList []Coin
+	if err := json.Unmarshal(body, &coinsList); err != nil {
+		return nil, fmt.Errorf("failure unmarshalling response body: %w", err)
+	}
+
+	if len(coinsList) == 0 {
+		return nil, errors.New("empty coingecko list")
+	}
+
+	return coinsList, nil
+}
+
+func (p *CoingeckoProvider) GetTokenInfo(ctx context.Context, tokenID string) (*TokenInfo, error) {
+	body, err := p.get(ctx, fmt.Sprintf(coingeckoCoinPath, tokenID))
+	if err != nil {
+		return nil, fmt.Errorf("failure retrieving coingecko coin info: %w", err)
+	}
+
+	var coinApiNativeInfo CoingeckoCoinInfo
+	if err := json.Unmarshal(body, &coinApiNativeInfo); err != nil {
+		return nil, fmt.Errorf("failure unmarshalling response body: %w", err)
+	}
+
+	return &TokenInfo{
+		ID:                  coinApiNativeInfo.ID,
+		Symbol:              coinApiNativeInfo.Symbol,
+		GenesisDate:         coinApiNativeInfo.GenesisDate,
+		MarketCap:           coinApiNativeInfo.MarketData.MarketCap.USD,
+		FDV:                 coinApiNativeInfo.MarketData.FullyDilutedValuation.USD,
+		Volume:              coinApiNativeInfo.MarketData.TotalVolume.USD,
+		ATHChangePercentage: coinApiNativeInfo.MarketData.ATHChangePercentage.USD,
+	}, nil
+}
+
+func (p *CoingeckoProvider) GetMarketChart(ctx context.Context, tokenID string, days int) ([]PricePoint, error) {
+	body, err := p.get(ctx, fmt.Sprintf(coingeckoMarketChartPath, tokenID, days))
+	if err != nil {
+		return nil, fmt.Errorf("failure retrieving coingecko market chart: %w", err)
+	}
+
+	var chart CoingeckoMarketChart
+	if err := json.Unmarshal(body, &chart); err != nil {
+		return nil, fmt.Errorf("failure unmarshalling response body: %w", err)
+	}
+
+	points := make([]PricePoint, 0, len(chart.Prices))
+	for _, price := range chart.Prices {
+		if len(price) != 2 {
+			continue
+		}
+		points = append(points, PricePoint{
+			Time:  time.UnixMilli(int64(price[0])).UTC(),
+			Price: price[1],
+		})
+	}
+	return points, nil
+}
+
+// get waits for the rate limiter and retries on rate limit and server errors according to the retry policy.
+func (p *CoingeckoProvider) get(ctx context.Context, path string) ([]byte, error) {
+	url := p.baseURL + path
+	var body []byte
+	err := p.retryPolicy.Do(ctx, "coingecko data", func() (err error) {
+		if err := p.reqsLimiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: %v", ErrTemporary, err)
+		}
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%w: failure reading response body: %v", ErrTemporary, err)
+		}
+
+		if r.StatusCode == http.StatusTooManyRequests {
+			p.reqsLimiter.RateLimited(retryAfter(r.Header.Get("Retry-After"), coingeckoDefaultRetryAfter))
+		}
+		if r.StatusCode != http.StatusOK {
+			return statusError("coingecko", r.StatusCode, body)
+		}
+		p.reqsLimiter.Success()
+		return nil
+	})
+	return body, err
+}
+
+// retryAfter parses Retry-After header given either in seconds or as HTTP date.
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,94 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func newCoingeckoStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	responses := map[string]string{
+		"/coins/list":                    `[{"id":"good-token","symbol":"good","platforms":{"ethereum":"0x2222222222222222222222222222222222222222"}}]`,
+		"/coins/good-token":              `{"id":"good-token","symbol":"good","genesis_date":"2023-01-15","market_data":{"market_cap":{"usd":10000000},"fully_diluted_valuation":{"usd":20000000},"total_volume":{"usd":1000000},"ath_change_percentage":{"usd":-40.5}}}`,
+		"/coins/good-token/market_chart": `{"prices":[[1685577600000,1.5],[1685664000000,1.25]]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCoingeckoProvider(t *testing.T) {
+	server := newCoingeckoStandIn(t)
+	p := NewCoingeckoProvider(server.URL, NewAdaptiveLimiter("coingecko", 1000, 1))
+
+	coins, err := p.GetTokensList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coins) != 1 || coins[0].ID != "good-token" || coins[0].Platforms["ethereum"] != "0x2222222222222222222222222222222222222222" {
+		t.Errorf("unexpected coins list %+v", coins)
+	}
+
+	info, err := p.GetTokenInfo(context.Background(), "good-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.MarketCap.Equal(decimal.NewFromInt(10000000)) || info.GenesisDate != "2023-01-15" ||
+		!info.ATHChangePercentage.Equal(decimal.RequireFromString("-40.5")) {
+		t.Errorf("unexpected token info %+v", info)
+	}
+
+	chart, err := p.GetMarketChart(context.Background(), "good-token", 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PricePoint{
+		{Time: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), Price: 1.5},
+		{Time: time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC), Price: 1.25},
+	}
+	if len(chart) != len(want) {
+		t.Fatalf("got %d price points, want %d", len(chart), len(want))
+	}
+	for i := range want {
+		if !chart[i].Time.Equal(want[i].Time) || chart[i].Price != want[i].Price {
+			t.Errorf("price point %d: got %+v, want %+v", i, chart[i], want[i])
+		}
+	}
+
+	if _, err := p.GetTokenInfo(context.Background(), "unknown-token"); err == nil {
+		t.Error("expected an error for unknown token")
+	}
+}
+
+func TestCoingeckoProviderStopsWhenContextIsDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	p := NewCoingeckoProvider(server.URL, NewAdaptiveLimiter("coingecko", 1000, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := p.GetTokenInfo(ctx, "good-token")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s, want right after the context is done", elapsed)
+	}
+}
@@ -19,3 +19,12 @@ type Balance struct {
 	Type    string `json:"type"`
 	Balance string `json:"quote"`
 }
+
+type transactionsParams struct {
+	PageNumber int `json:"page-number"`
+	PageSize   int `json:"page-size"`
+}
+
+type historicalBalancesParams struct {
+	BlockHeight int `json:"block-height"`
+}
@@ -0,0 +1,50 @@
+package apiclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrRateLimited is returned when an API rejected the request because of rate limiting.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrTemporary is returned on API failures that may succeed when retried.
+	ErrTemporary = errors.New("temporary API error")
+	// ErrUnsupportedChain is returned for chains the API is not used with.
+	ErrUnsupportedChain = errors.New("not supported chain")
+)
+
+// covalentError wraps errors of the covalent client, which are plain messages, with typed errors.
+func covalentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case msg == "Rate limit exceeded":
+		return fmt.Errorf("%w: %v", ErrRateLimited, err)
+	case msg == "backend queue is full and cannot accept request",
+		msg == "invalid character '<' looking for beginning of value",
+		msg == "Database statement timeout exceeded",
+		strings.Contains(msg, "timeout"):
+		return fmt.Errorf("%w: %v", ErrTemporary, err)
+	}
+	return err
+}
+
+// statusError returns a typed error for a non-OK HTTP response status.
+func statusError(api string, status int, body []byte) error {
+	err := fmt.Errorf("%s response status: %d; body: %s", api, status, string(body))
+	switch {
+	case status == 429:
+		return fmt.Errorf("%w: %v", ErrRateLimited, err)
+	case status >= 500:
+		return fmt.Errorf("%w: %v", ErrTemporary, err)
+	}
+	return err
+}
+
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTemporary)
+}
@@ -0,0 +1,75 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cshields143/govalent/class_a"
+)
+
+// FakeAPIClient is an in-memory APIClienter serving prepared responses, for tests and offline runs.
+// Addresses used as keys must be lowercase. Balances are the same at every block.
+type FakeAPIClient struct {
+	Holders      map[Chain]map[string][]class_a.Portfolio   `json:"holders"`      // token address to holders
+	Balances     map[Chain]map[string][]class_a.Portfolio   `json:"balances"`     // holder address to balances
+	Blocks       map[Chain]map[string]int                   `json:"blocks"`       // date in 2006-01-02 format to block
+	Transactions map[Chain]map[string][]class_a.Transaction `json:"transactions"` // address to transactions
+}
+
+// NewFakeAPIClientFromFile reads responses of the fake client from a JSON file.
+func NewFakeAPIClientFromFile(path string) (*FakeAPIClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c FakeAPIClient
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failure unmarshalling fake responses: %w", err)
+	}
+	return &c, nil
+}
+
+func (c *FakeAPIClient) GetTokenHolders(ctx context.Context, req GetTokenHoldersReq) ([]class_a.Portfolio, error) {
+	if _, ok := Chains[req.Chain]; !ok {
+		return nil, ErrUnsupportedChain
+	}
+	holders := c.Holders[req.Chain][strings.ToLower(req.TokenAddress)]
+	if req.MaxHolders > 0 && len(holders) > req.MaxHolders {
+		holders = holders[:req.MaxHolders]
+	}
+	return holders, ctx.Err()
+}
+
+func (c *FakeAPIClient) GetAddressBalances(ctx context.Context, req GetAddressBalancesReq) ([]class_a.Portfolio, error) {
+	if _, ok := Chains[req.Chain]; !ok {
+		return nil, ErrUnsupportedChain
+	}
+	return c.Balances[req.Chain][strings.ToLower(req.Address)], ctx.Err()
+}
+
+func (c *FakeAPIClient) GetBlockByDate(ctx context.Context, req GetBlockByDateReq) (*int, error) {
+	if _, ok := Chains[req.Chain]; !ok {
+		return nil, ErrUnsupportedChain
+	}
+	block, ok := c.Blocks[req.Chain][req.Date.Format("2006-01-02")]
+	if !ok {
+		return nil, fmt.Errorf("no block for date %s", req.Date.Format("2006-01-02"))
+	}
+	return &block, ctx.Err()
+}
+
+func (c *FakeAPIClient) GetAddressTransactions(ctx context.Context, req GetAddressTransactionsReq) ([]class_a.Transaction, error) {
+	if _, ok := Chains[req.Chain]; !ok {
+		return nil, ErrUnsupportedChain
+	}
+	var transactions []class_a.Transaction
+	for _, tx := range c.Transactions[req.Chain][strings.ToLower(req.Address)] {
+		if !tx.BlockSignedAt.Before(req.Since) {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions, ctx.Err()
+}
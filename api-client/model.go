@@ -15,4 +15,5 @@ var Chains = map[Chain]string{ETH: "1", MATIC: "137", ARBITRUM: "42161", AVALANC
 
 var CoingeckoPlatforms = map[Chain]string{ETH: "ethereum", MATIC: "polygon-pos", ARBITRUM: "arbitrum-one", AVALANCHE: "avalanche", FANTOM: "fantom", OPTIMISM: "optimism"}
 
-var MoralisChain = map[Chain]string{ETH: "eth"}
+// MoralisChain lists chains supported by Moralis date to block endpoint, other chains fall back to Covalent.
+var MoralisChain = map[Chain]string{ETH: "eth", MATIC: "polygon", ARBITRUM: "arbitrum", AVALANCHE: "avalanche", FANTOM: "fantom"}
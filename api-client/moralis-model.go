@@ -0,0 +1,7 @@
+package apiclient
+
+type MoralisDateToBlockResponse struct {
+	Block     *int   `json:"block"`
+	Date      string `json:"date"`
+	Timestamp int64  `json:"timestamp"`
+}
@@ -0,0 +1,138 @@
+package apiclient
+
+import (
+	"aper/config"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultCovalentRate  = 20
+	defaultMoralisRate   = 25
+	defaultCoingeckoRate = 0.5
+
+	// rate is changed at most once per interval, so that a burst of concurrent
+	// rate limited requests halves it only once
+	rateAdjustInterval = time.Second
+	// the rate never drops below this fraction of the max rate
+	minRateFraction = 0.01
+	// fraction of the max rate added back per interval of successful requests
+	rateIncreaseFraction = 0.1
+)
+
+// AdaptiveLimiter limits requests sent to an API using AIMD: the rate is halved when the API
+// reports rate limiting and increased additively on successful requests, up to the max rate.
+type AdaptiveLimiter struct {
+	name    string
+	lock    *sync.Mutex
+	limiter *rate.Limiter
+	max     rate.Limit
+	// requests wait until pausedUntil, set from Retry-After
+	pausedUntil time.Time
+	adjustedAt  time.Time
+}
+
+func NewAdaptiveLimiter(name string, reqsPerSecond float64, burst int) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		name:    name,
+		lock:    &sync.Mutex{},
+		limiter: rate.NewLimiter(rate.Limit(reqsPerSecond), burst),
+		max:     rate.Limit(reqsPerSecond),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done.
+func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.lock.Unlock()
+
+	if pause > 0 {
+		if err := sleep(ctx, pause); err != nil {
+			return err
+		}
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Success increases the rate after a successful request.
+func (l *AdaptiveLimiter) Success() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	current := l.limiter.Limit()
+	if current >= l.max || time.Since(l.adjustedAt) < rateAdjustInterval {
+		return
+	}
+	increased := current + l.max*rateIncreaseFraction
+	if increased > l.max {
+		increased = l.max
+	}
+	l.limiter.SetLimit(increased)
+	l.adjustedAt = time.Now()
+}
+
+// RateLimited halves the rate after the API reported rate limiting. When retryAfter is positive
+// no requests are let through until it passes.
+func (l *AdaptiveLimiter) RateLimited(retryAfter time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if retryAfter > 0 {
+		if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+			l.pausedUntil = until
+			fmt.Printf("%s rate limit reached, waiting %s...\n", l.name, retryAfter.Round(time.Second))
+		}
+	}
+
+	if time.Since(l.adjustedAt) < rateAdjustInterval {
+		return
+	}
+	decreased := l.limiter.Limit() / 2
+	if min := l.max * minRateFraction; decreased < min {
+		decreased = min
+	}
+	l.limiter.SetLimit(decreased)
+	l.adjustedAt = time.Now()
+	fmt.Printf("%s rate limit reached, slowing down to %.2f req/s\n", l.name, float64(decreased))
+}
+
+// Limit returns the current rate in requests per second.
+func (l *AdaptiveLimiter) Limit() float64 {
+	return float64(l.limiter.Limit())
+}
+
+func (l *AdaptiveLimiter) String() string {
+	return fmt.Sprintf("%s %.2f req/s", l.name, l.Limit())
+}
+
+// Limiters are the rate limiters of all used APIs.
+type Limiters struct {
+	Covalent  *AdaptiveLimiter
+	Moralis   *AdaptiveLimiter
+	Coingecko *AdaptiveLimiter
+}
+
+// NewLimiters creates the limiters with max rates from config, using defaults for rates not set.
+func NewLimiters(cfg config.RateLimits) *Limiters {
+	return &Limiters{
+		Covalent:  NewAdaptiveLimiter("covalent", rateOrDefault(cfg.Covalent, defaultCovalentRate), 1),
+		Moralis:   NewAdaptiveLimiter("moralis", rateOrDefault(cfg.Moralis, defaultMoralisRate), 1),
+		Coingecko: NewAdaptiveLimiter("coingecko", rateOrDefault(cfg.Coingecko, defaultCoingeckoRate), 5),
+	}
+}
+
+func (l *Limiters) String() string {
+	return fmt.Sprintf("%s, %s, %s", l.Covalent, l.Moralis, l.Coingecko)
+}
+
+func rateOrDefault(reqsPerSecond, fallback float64) float64 {
+	if reqsPerSecond > 0 {
+		return reqsPerSecond
+	}
+	return fallback
+}
@@ -0,0 +1,55 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries requests failing with ErrRateLimited or ErrTemporary,
+// waiting exponentially longer between attempts.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 6,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// Do calls request until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or ctx is done. The last error is returned.
+func (p RetryPolicy) Do(ctx context.Context, what string, request func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = request()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			return fmt.Errorf("failure retrieving %s after %d attempts: %w", what, attempt, err)
+		}
+
+		delay := p.backoff(attempt)
+		fmt.Printf("error retrieving %s: %s, retrying in %s...\n", what, err, delay.Round(time.Millisecond))
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns the delay after the given attempt: exponential growth capped at MaxDelay,
+// with jitter so that concurrent requests do not retry at once.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if shift := attempt - 1; shift < 30 {
+		if d := p.BaseDelay << shift; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
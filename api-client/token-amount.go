@@ -0,0 +1,59 @@
+package apiclient
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// TokenAmount is a token balance as returned by APIs, in raw integer units, with the contract decimals
+// needed to convert it to human readable units.
+type TokenAmount struct {
+	Raw      decimal.Decimal `json:"raw"`
+	Decimals int32           `json:"decimals"`
+}
+
+func NewTokenAmount(raw string, decimals int) (TokenAmount, error) {
+	if decimals < 0 {
+		return TokenAmount{}, fmt.Errorf("negative contract decimals: %d", decimals)
+	}
+	value, err := decimal.NewFromString(raw)
+	if err != nil {
+		return TokenAmount{}, fmt.Errorf("invalid raw token amount %q: %w", raw, err)
+	}
+	return TokenAmount{
+		Raw:      value,
+		Decimals: int32(decimals),
+	}, nil
+}
+
+// TokenAmountFromUnits converts an amount given in human readable units to raw integer units.
+func TokenAmountFromUnits(units decimal.Decimal, decimals int) TokenAmount {
+	return TokenAmount{
+		Raw:      units.Shift(int32(decimals)).Truncate(0),
+		Decimals: int32(decimals),
+	}
+}
+
+// Units returns the amount in human readable units, i.e. raw / 10^decimals.
+func (a TokenAmount) Units() decimal.Decimal {
+	return a.Raw.Shift(-a.Decimals)
+}
+
+func (a TokenAmount) Add(b TokenAmount) TokenAmount {
+	if a.Decimals != b.Decimals {
+		return TokenAmountFromUnits(a.Units().Add(b.Units()), int(a.Decimals))
+	}
+	return TokenAmount{
+		Raw:      a.Raw.Add(b.Raw),
+		Decimals: a.Decimals,
+	}
+}
+
+func (a TokenAmount) LessThanUnits(units decimal.Decimal) bool {
+	return a.Units().LessThan(units)
+}
+
+func (a TokenAmount) String() string {
+	return a.Units().String()
+}
@@ -0,0 +1,119 @@
+package apiclient
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNewTokenAmount(t *testing.T) {
+	tests := []struct {
+		desc      string
+		raw       string
+		decimals  int
+		wantUnits string
+		wantErr   bool
+	}{
+		{desc: "18 decimals", raw: "1500000000000000000000", decimals: 18, wantUnits: "1500"},
+		{desc: "6 decimals", raw: "2500000", decimals: 6, wantUnits: "2.5"},
+		{desc: "0 decimals", raw: "42", decimals: 0, wantUnits: "42"},
+		{desc: "less than one unit", raw: "1", decimals: 18, wantUnits: "0.000000000000000001"},
+		{desc: "zero", raw: "0", decimals: 18, wantUnits: "0"},
+		{desc: "larger than int64", raw: "123456789012345678901234567890", decimals: 18, wantUnits: "123456789012.34567890123456789"},
+		{desc: "invalid raw", raw: "abc", decimals: 18, wantErr: true},
+		{desc: "empty raw", raw: "", decimals: 18, wantErr: true},
+		{desc: "negative decimals", raw: "1", decimals: -1, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			amount, err := NewTokenAmount(tc.raw, tc.decimals)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got amount %s", amount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := amount.Units().String(); got != tc.wantUnits {
+				t.Errorf("units: got %s, want %s", got, tc.wantUnits)
+			}
+		})
+	}
+}
+
+func TestTokenAmountFromUnits(t *testing.T) {
+	tests := []struct {
+		desc     string
+		units    string
+		decimals int
+		wantRaw  string
+	}{
+		{desc: "18 decimals", units: "100", decimals: 18, wantRaw: "100000000000000000000"},
+		{desc: "fractional units", units: "0.5", decimals: 6, wantRaw: "500000"},
+		{desc: "truncates below smallest unit", units: "0.0000001", decimals: 6, wantRaw: "0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			amount := TokenAmountFromUnits(decimal.RequireFromString(tc.units), tc.decimals)
+			if got := amount.Raw.String(); got != tc.wantRaw {
+				t.Errorf("raw: got %s, want %s", got, tc.wantRaw)
+			}
+		})
+	}
+}
+
+func TestTokenAmountLessThanUnits(t *testing.T) {
+	tests := []struct {
+		desc     string
+		raw      string
+		decimals int
+		units    int64
+		want     bool
+	}{
+		{desc: "99 tokens below 100", raw: "99000000000000000000", decimals: 18, units: 100, want: true},
+		{desc: "100 tokens not below 100", raw: "100000000000000000000", decimals: 18, units: 100, want: false},
+		{desc: "101 tokens not below 100", raw: "101000000000000000000", decimals: 18, units: 100, want: false},
+		{desc: "raw above threshold but units below", raw: "1000", decimals: 6, units: 100, want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			amount, err := NewTokenAmount(tc.raw, tc.decimals)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := amount.LessThanUnits(decimal.NewFromInt(tc.units)); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenAmountAdd(t *testing.T) {
+	tests := []struct {
+		desc      string
+		a, b      TokenAmount
+		wantUnits string
+	}{
+		{
+			desc:      "same decimals",
+			a:         TokenAmount{Raw: decimal.RequireFromString("1500000"), Decimals: 6},
+			b:         TokenAmount{Raw: decimal.RequireFromString("500000"), Decimals: 6},
+			wantUnits: "2",
+		},
+		{
+			desc:      "different decimals",
+			a:         TokenAmount{Raw: decimal.RequireFromString("1000000"), Decimals: 6},
+			b:         TokenAmount{Raw: decimal.RequireFromString("1000000000000000000"), Decimals: 18},
+			wantUnits: "2",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.a.Add(tc.b).Units().String(); got != tc.wantUnits {
+				t.Errorf("got %s, want %s", got, tc.wantUnits)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type TokenMetadataProvider interface {
+	GetTokensList(ctx context.Context) ([]Coin, error)
+	GetTokenInfo(ctx context.Context, tokenID string) (*TokenInfo, error)
+	GetMarketChart(ctx context.Context, tokenID string, days int) ([]PricePoint, error)
+}
+
+type Coin struct {
+	ID        string            `json:"id"`
+	Symbol    string            `json:"symbol"`
+	Platforms map[string]string `json:"platforms"`
+}
+
+type TokenInfo struct {
+	ID                  string          `json:"id"`
+	Symbol              string          `json:"symbol"`
+	MarketCap           decimal.Decimal `json:"market_cap"`
+	FDV                 decimal.Decimal `json:"fdv"`
+	Volume              decimal.Decimal `json:"volume"`
+	ATHChangePercentage decimal.Decimal `json:"ath_change_percentage"`
+	GenesisDate         string          `json:"genesis_date"`
+}
+
+type PricePoint struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+type tokenMetadataSnapshot struct {
+	Coins  []Coin                  `json:"coins"`
+	Tokens map[string]*TokenInfo   `json:"tokens"` // token ID to token info
+	Charts map[string][]PricePoint `json:"charts"` // token ID and days to price chart
+}
+
+func chartKey(tokenID string, days int) string {
+	return fmt.Sprintf("%s/%d", tokenID, days)
+}
+
+// SnapshotProvider serves token metadata recorded in a local file, so that a run can be reproduced offline.
+type SnapshotProvider struct {
+	snapshot tokenMetadataSnapshot
+}
+
+func NewSnapshotProvider(path string) (TokenMetadataProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot tokenMetadataSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotProvider{
+		snapshot: snapshot,
+	}, nil
+}
+
+func (p *SnapshotProvider) GetTokensList(ctx context.Context) ([]Coin, error) {
+	if len(p.snapshot.Coins) == 0 {
+		return nil, errors.New("empty tokens list in snapshot")
+	}
+	return p.snapshot.Coins, nil
+}
+
+func (p *SnapshotProvider) GetTokenInfo(ctx context.Context, tokenID string) (*TokenInfo, error) {
+	tokenInfo, ok := p.snapshot.Tokens[tokenID]
+	if !ok {
+		return nil, fmt.Errorf("token %s not found in snapshot", tokenID)
+	}
+	return tokenInfo, nil
+}
+
+func (p *SnapshotProvider) GetMarketChart(ctx context.Context, tokenID string, days int) ([]PricePoint, error) {
+	chart, ok := p.snapshot.Charts[chartKey(tokenID, days)]
+	if !ok {
+		return nil, fmt.Errorf("market chart of token %s not found in snapshot", tokenID)
+	}
+	return chart, nil
+}
+
+// RecordingProvider passes calls through to another provider and keeps the responses,
+// so that they can be saved as a snapshot for SnapshotProvider.
+type RecordingProvider struct {
+	provider TokenMetadataProvider
+	lock     *sync.Mutex
+	snapshot tokenMetadataSnapshot
+}
+
+func NewRecordingProvider(provider TokenMetadataProvider) *RecordingProvider {
+	return &RecordingProvider{
+		provider: provider,
+		lock:     &sync.Mutex{},
+		snapshot: tokenMetadataSnapshot{
+			Tokens: make(map[string]*TokenInfo),
+			Charts: make(map[string][]PricePoint),
+		},
+	}
+}
+
+func (p *RecordingProvider) GetTokensList(ctx context.Context) ([]Coin, error) {
+	coins, err := p.provider.GetTokensList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.snapshot.Coins = coins
+	p.lock.Unlock()
+
+	return coins, nil
+}
+
+func (p *RecordingProvider) GetTokenInfo(ctx context.Context, tokenID string) (*TokenInfo, error) {
+	tokenInfo, err := p.provider.GetTokenInfo(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.snapshot.Tokens[tokenID] = tokenInfo
+	p.lock.Unlock()
+
+	return tokenInfo, nil
+}
+
+func (p *RecordingProvider) GetMarketChart(ctx context.Context, tokenID string, days int) ([]PricePoint, error) {
+	chart, err := p.provider.GetMarketChart(ctx, tokenID, days)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.snapshot.Charts[chartKey(tokenID, days)] = chart
+	p.lock.Unlock()
+
+	return chart, nil
+}
+
+func (p *RecordingProvider) Save(path string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	data, err := json.Marshal(p.snapshot)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -1,22 +1,20 @@
 package cmd
 
 import (
+	"aper/analysis"
 	apiclient "aper/api-client"
 	"aper/config"
-	"encoding/csv"
-	"encoding/json"
+	"aper/output"
+	"aper/screening"
+	"aper/store"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
-	"sort"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/cshields143/govalent/class_a"
-	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -39,463 +37,527 @@ func init() {
 	_ = balancesOfTokensHolders.MarkPersistentFlagRequired("whaleThreshold")
 
 	balancesOfTokensHolders.PersistentFlags().StringVar(&date, "date", "", "")
+
+	balancesOfTokensHolders.PersistentFlags().StringVar(&metadataSnapshotPath, "metadataSnapshot", "", "read token metadata from a snapshot file instead of coingecko")
+	balancesOfTokensHolders.PersistentFlags().StringVar(&recordMetadataSnapshotPath, "recordMetadataSnapshot", "", "save token metadata retrieved during the run to a snapshot file")
+
+	balancesOfTokensHolders.PersistentFlags().StringVar(&rulesPath, "rules", "", "YAML file with token screening rules, overrides rules from config")
+
+	balancesOfTokensHolders.PersistentFlags().StringVar(&resumeRunID, "resume", "", "run ID to resume, holders processed in that run are skipped")
+
+	balancesOfTokensHolders.PersistentFlags().StringVar(&sortBy, "sort-by", "value",
+		"order of found tokens: value, holders, share, median, mean, gini or hhi, optionally followed by :asc or :desc")
+
+	balancesOfTokensHolders.PersistentFlags().IntVar(&maxHolders, "maxHolders", 0, "stop after retrieving that many holders, 0 means all")
+
+	balancesOfTokensHolders.PersistentFlags().StringVar(&dbPath, "db", "", "SQLite database recording the run, its holders, their balances and found tokens")
 }
 
 const (
-	configPath            = "/Users/michal.gil/go/src/aper/config/config.yaml"
-	coingeckoURL          = "https://www.coingecko.com/en/coins/%s"
-	coingeckoCoinsListURL = "https://api.coingecko.com/api/v3/coins/list?include_platform=true"
-	coingeckoCoinURL      = "https://api.coingecko.com/api/v3/coins/%s?localization=false&tickers=false&community_data=false&developer_data=false&sparkline=false"
-	resultsPathTokens     = "./results/tokens"
-	resultsPathWhales     = "./results/whales"
-	dateFormat            = "2006-01-02"
+	coingeckoURL = "https://www.coingecko.com/en/coins/%s"
+	dateFormat   = "2006-01-02"
 )
 
 var (
 	cfg                                      config.Config
 	tokenAddress                             string
-	tokenSymbol                              string
 	tokenChain                               string
 	minTokenQnt                              int
 	minHoldingUSDValueStr, whaleThresholdStr string
-	minHoldingUSDValue, whaleThreshold       decimal.Decimal
-	apiClient                                apiclient.APIClienter
+	metadataSnapshotPath                     string
+	recordMetadataSnapshotPath               string
 	date                                     string
+	maxHolders                               int
+	rulesPath                                string
+	resumeRunID                              string
+	sortBy                                   string
+	dbPath                                   string
 )
 
-type coins struct {
-	lock               *sync.RWMutex
-	coingeckoTokensMap map[apiclient.Chain]map[string]*tokenInfo // chain to token symbol to token info
-}
-
-type whales struct {
-	lock *sync.RWMutex
-	list map[string]string // address to portfolio value
-}
-
-type holdings struct {
-	lock *sync.RWMutex
-	list map[string]decimal.Decimal // token symbol to quote to be able to sort
-}
-
 var balancesOfTokensHolders = &cobra.Command{
 	Use:   "balancesOfTokensHolders",
 	Short: "Retrieve current holders of a token",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		initConfig(configPath)
-
+		initConfig()
+		initOutputFormat()
+		ctx := cmd.Context()
+
+		opts := analysis.Options{
+			TokenAddress: tokenAddress,
+			TokenChain:   apiclient.Chain(tokenChain),
+			Chains:       cfg.Chains,
+			MinTokenQnt:  minTokenQnt,
+			MaxHolders:   maxHolders,
+			Concurrency:  concurrency,
+			Rules:        loadRules(),
+		}
 		var err error
-		minHoldingUSDValue, err = decimal.NewFromString(minHoldingUSDValueStr)
+		opts.MinHoldingUSDValue, err = decimal.NewFromString(minHoldingUSDValueStr)
 		if err != nil {
 			log.Fatalf("error parsing minimal holding value %v: %v", minHoldingUSDValueStr, err)
 		}
-		whaleThreshold, err = decimal.NewFromString(whaleThresholdStr)
+		opts.WhaleThreshold, err = decimal.NewFromString(whaleThresholdStr)
 		if err != nil {
 			log.Fatalf("error parsing whale threshold %v: %v", whaleThresholdStr, err)
 		}
-
-		apiClient = apiclient.NewAPIClient(&cfg)
-
-		tokenChainC := apiclient.Chain(tokenChain)
-
-		var block *int
 		if date != "" {
-			dateTime, err := time.Parse(dateFormat, date)
+			opts.Date, err = time.Parse(dateFormat, date)
 			if err != nil {
 				log.Fatalf("error parsing date %v: %v", date, err)
 			}
-			block, err = apiClient.GetBlockByDate(apiclient.GetBlockByDateReq{
-				Chain: tokenChainC,
-				Date:  dateTime,
-			})
-			log.Printf("block: %d", *block)
-			if err != nil {
-				log.Fatalf("error retrieving block by date: %v", err)
-			}
-			if block == nil {
-				log.Fatal("no block found for given date")
-			}
 		}
 
-		coins := coins{
-			lock:               &sync.RWMutex{},
-			coingeckoTokensMap: make(map[apiclient.Chain]map[string]*tokenInfo),
+		sortTokens, err := parseSortBy(sortBy)
+		if err != nil {
+			log.Fatalf("error parsing sort order %v: %v", sortBy, err)
 		}
 
-		// saveCoingeckoTokensList(coins)
-		// os.Exit(0)
-
-		initCoingeckoTokensMap(coins)
+		var db *store.Store
+		if dbPath != "" {
+			db, err = store.Open(dbPath)
+			if err != nil {
+				log.Fatalf("error opening database %v: %v", dbPath, err)
+			}
+			defer db.Close()
+		}
 
-		fmt.Printf("Retrieving holders...\n")
-		holders, err := apiClient.GetTokenHolders(apiclient.Chain(tokenChainC), tokenAddress, block)
+		cache := initCache()
+		metadataProvider, recorder := newMetadataProvider(cache)
+
+		var (
+			runID    string
+			runDir   string
+			manifest *runManifest
+			journal  *checkpoint
+		)
+		analyzer, err := analysis.NewAnalyzer(opts, analysis.Dependencies{
+			APIClient: newAPIClient(cache),
+			Metadata:  metadataProvider,
+			OpenJournal: func(start analysis.Start) (analysis.Journal, error) {
+				runID, runDir, manifest = openRun(cmd, start, opts.Rules)
+				manifest.addFile(saveHoldersInAFile(runDir, start.Holders))
+				journal, err = openCheckpoint(runDir)
+				if err != nil || db == nil {
+					return journal, err
+				}
+				if err := recordRunStart(db, manifest, start); err != nil {
+					return nil, err
+				}
+				return recordedJournal{checkpoint: journal, db: db, runID: runID}, nil
+			},
+			Progress: func(p analysis.Progress) {
+				if p.Stage == analysis.StageBalances {
+					printProgress(p.Processed, p.Total)
+				}
+			},
+			Log: logf,
+		})
 		if err != nil {
-			log.Fatalf("error retrieving token holders for address %v: %v", tokenAddress, err)
-		}
-		fmt.Printf("Found %d holders\n", len(holders))
-		if len(holders) == 0 {
-			log.Fatal("Exiting...")
+			log.Fatalf("error initializing analysis: %v", err)
 		}
 
-		tokenSymbol = holders[0].ContractTickerSymbol
-
-		whales := whales{
-			lock: &sync.RWMutex{},
-			list: make(map[string]string, 0),
+		result, err := analyzer.Run(ctx)
+		if journal != nil {
+			journal.close()
+		}
+		if err != nil {
+			log.Fatalf("error analysing holders of %v: %v", tokenAddress, err)
+		}
+		if result.Interrupted {
+			fmt.Printf("Interrupted, saving partial results. Continue with --resume %s\n", runID)
 		}
 
-		var wg sync.WaitGroup
 		for _, chain := range cfg.Chains {
-			fmt.Printf("Processing %s chain...\n", chain)
+			fmt.Printf("%s chain:\n", chain)
+			manifest.addFile(saveFoundTokensInAFile(runDir, chain, result.Tokens[chain], sortTokens))
+			manifest.addFile(saveSkippedTokensInAFile(runDir, chain, result.SkippedTokens[chain]))
+		}
+		manifest.addFile(saveFoundWhalesInAFile(runDir, result.Whales))
+		manifest.finish(result.Interrupted)
 
-			holdings := holdings{
-				lock: &sync.RWMutex{},
-				list: make(map[string]decimal.Decimal, 0),
+		if db != nil {
+			if err := db.AddResult(runID, result); err != nil {
+				log.Fatalf("error recording results in database: %v", err)
 			}
+			if err := db.FinishRun(runID, time.Now(), result.Interrupted, result.AnalysedHolders); err != nil {
+				log.Fatalf("error recording results in database: %v", err)
+			}
+		}
 
-			for _, holder := range holders {
-				if shouldSkipHolder(&holder) {
-					continue
-				}
-
-				wg.Add(1)
-				holderAddress := holder.Address
-
-				go func() {
-					processHolder(holderAddress, chain, holdings, whales, coins)
-					wg.Done()
-				}()
+		if recorder != nil {
+			if err := recorder.Save(recordMetadataSnapshotPath); err != nil {
+				log.Fatalf("error saving metadata snapshot %v: %v", recordMetadataSnapshotPath, err)
 			}
-			wg.Wait()
-			saveFoundTokensInAFile(chain, holdings.list, coins)
 		}
-		saveFoundWhalesInAFile(whales.list)
-		return nil
+		return ctx.Err()
 	},
 }
 
-func processHolder(holderAddress, chain string, holdings holdings, whales whales, coins coins) {
-	balances, err := apiClient.GetAddressBalances(apiclient.GetAddressBalancesReq{
-		Chain:   apiclient.Chain(chain),
-		Address: holderAddress,
-	})
-	if err != nil {
-		fmt.Printf("error retrieving balances for chain %v, address: %v; %v\n", chain, holderAddress, err)
-		return
-	}
-
-	portfolioValue := decimal.NewFromInt(0)
-	for _, balance := range balances {
-		quote := decimal.NewFromFloat(balance.Quote)
-		portfolioValue = portfolioValue.Add(quote)
-
-		if shouldSkipBalance(&balance) {
-			continue
+// newMetadataProvider returns the token metadata provider set up by flags and config, and the recorder
+// wrapping it when the metadata is to be saved to a snapshot.
+func newMetadataProvider(cache *apiclient.Cache) (apiclient.TokenMetadataProvider, *apiclient.RecordingProvider) {
+	var metadataProvider apiclient.TokenMetadataProvider
+	if metadataSnapshotPath != "" {
+		var err error
+		metadataProvider, err = apiclient.NewSnapshotProvider(metadataSnapshotPath)
+		if err != nil {
+			log.Fatalf("error reading metadata snapshot %v: %v", metadataSnapshotPath, err)
 		}
-		if !minHoldingUSDValue.LessThanOrEqual(quote) {
-			continue
+	} else {
+		metadataProvider = apiclient.NewCoingeckoProvider(cfg.CoingeckoURL, rateLimiters.Coingecko)
+		if cache != nil {
+			metadataProvider = apiclient.NewCachedMetadataProvider(metadataProvider, cache)
 		}
-		skip, err := shouldSkipToken(chain, balance.ContractTickerSymbol, coins)
-		if err != nil {
-			fmt.Printf("error checking for token skip: %s\n", err)
-			return
+	}
+	if recordMetadataSnapshotPath == "" {
+		return metadataProvider, nil
+	}
+	recorder := apiclient.NewRecordingProvider(metadataProvider)
+	return recorder, recorder
+}
+
+// openRun creates the run directory, or reuses the one of the resumed run, and saves the run manifest.
+func openRun(cmd *cobra.Command, start analysis.Start, rules config.Rules) (string, string, *runManifest) {
+	var (
+		runID, runDir string
+		resumed       *runManifest
+	)
+	if resumeRunID != "" {
+		runID, runDir = resumeRunID, filepath.Join(outDir, resumeRunID)
+		var err error
+		if resumed, err = readRunManifest(runDir); err != nil {
+			log.Fatalf("error resuming run %v: %v", runID, err)
 		}
-		if skip {
-			continue
+		fmt.Printf("Saving results in %s\n", runDir)
+	} else {
+		block := "latest"
+		if b, ok := start.Blocks[apiclient.Chain(tokenChain)]; ok {
+			block = fmt.Sprint(b)
 		}
+		runID, runDir = createRunDir(newRunID(start.TokenSymbol, tokenChain, block))
+	}
+	fmt.Printf("Run ID: %s\n", runID)
 
-		holdings.lock.Lock()
-		if v, ok := holdings.list[balance.ContractTickerSymbol]; ok {
-			holdings.list[balance.ContractTickerSymbol] = v.Add(quote)
-		} else {
-			holdings.list[balance.ContractTickerSymbol] = quote
+	manifest := newRunManifest(cmd, runID, runDir)
+	manifest.TokenSymbol = start.TokenSymbol
+	manifest.Rules = &rules
+	manifest.Blocks = make(map[string]int, len(start.Blocks))
+	for chain, block := range start.Blocks {
+		manifest.Blocks[string(chain)] = block
+	}
+	if resumed != nil {
+		if err := resumed.checkResumedRun(manifest); err != nil {
+			log.Fatalf("error resuming run %v: %v", runID, err)
 		}
-		holdings.lock.Unlock()
 	}
-	if !portfolioValue.LessThan(whaleThreshold) {
-		whales.lock.Lock()
-		whales.list[holderAddress] = shortValue(portfolioValue)
-		whales.lock.Unlock()
+	manifest.save()
+	return runID, runDir, manifest
+}
+
+type tokenRow struct {
+	Symbol         string   `json:"symbol" parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address        string   `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Chain          string   `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CoingeckoID    string   `json:"coingecko_id" parquet:"name=coingecko_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Quantity       string   `json:"quantity" parquet:"name=quantity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value          float64  `json:"value_usd" parquet:"name=value_usd, type=DOUBLE"`
+	Holders        int64    `json:"holders" parquet:"name=holders, type=INT64"`
+	HoldersShare   float64  `json:"holders_share" parquet:"name=holders_share, type=DOUBLE"` // percentage of analysed holders
+	MedianPosition float64  `json:"median_position_usd" parquet:"name=median_position_usd, type=DOUBLE"`
+	MeanPosition   float64  `json:"mean_position_usd" parquet:"name=mean_position_usd, type=DOUBLE"`
+	Gini           float64  `json:"gini" parquet:"name=gini, type=DOUBLE"`
+	HHI            float64  `json:"hhi" parquet:"name=hhi, type=DOUBLE"`
+	MarketCap      float64  `json:"market_cap" parquet:"name=market_cap, type=DOUBLE"`
+	GenesisDate    string   `json:"genesis_date" parquet:"name=genesis_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Drawdown       *float64 `json:"drawdown" parquet:"name=drawdown, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Trend30d       *float64 `json:"trend_30d" parquet:"name=trend_30d, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Trend90d       *float64 `json:"trend_90d" parquet:"name=trend_90d, type=DOUBLE, repetitiontype=OPTIONAL"`
+	DecayScore     *float64 `json:"decay_score" parquet:"name=decay_score, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+func (r tokenRow) CSVHeader() []string {
+	return []string{"symbol", "address", "chain", "info", "quantity", "value", "holders", "holders %",
+		"median position", "mean position", "gini", "hhi", "market cap", "genesis date",
+		"drawdown %", "trend 30d %", "trend 90d %", "decay score"}
+}
+
+func (r tokenRow) CSVRecord() []string {
+	var info string
+	if r.CoingeckoID != "" {
+		info = fmt.Sprintf(coingeckoURL, r.CoingeckoID)
 	}
+	return []string{r.Symbol, r.Address, r.Chain, info, r.Quantity, formatFloat(r.Value), fmt.Sprint(r.Holders),
+		formatFloat(r.HoldersShare), formatFloat(r.MedianPosition), formatFloat(r.MeanPosition),
+		strconv.FormatFloat(r.Gini, 'f', 4, 64), strconv.FormatFloat(r.HHI, 'f', 4, 64),
+		formatFloat(r.MarketCap), r.GenesisDate,
+		formatOptional(r.Drawdown), formatOptional(r.Trend30d), formatOptional(r.Trend90d), formatOptional(r.DecayScore)}
 }
 
-type coingeckoCoin struct {
-	ID        string            `json:"id"`
-	Symbol    string            `json:"symbol"`
-	Platforms map[string]string `json:"platforms"`
+type skippedTokenRow struct {
+	Symbol  string  `json:"symbol" parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Chain   string  `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rule    string  `json:"rule" parquet:"name=rule, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value   float64 `json:"value_usd" parquet:"name=value_usd, type=DOUBLE"`
 }
 
-type tokenInfo struct {
-	ID          string
-	Symbol      string
-	MarketCap   decimal.Decimal
-	GenesisDate string
+func (r skippedTokenRow) CSVHeader() []string {
+	return []string{"symbol", "address", "chain", "rule", "value"}
 }
 
-func (t *tokenInfo) toCsvRow() []string {
-	return []string{t.ID, t.Symbol, t.MarketCap.String(), t.GenesisDate}
+func (r skippedTokenRow) CSVRecord() []string {
+	return []string{r.Symbol, r.Address, r.Chain, r.Rule, formatFloat(r.Value)}
 }
 
-func httpGetCoingeckoTokensList() ([]coingeckoCoin, error) {
-	r, err := http.Get(coingeckoCoinsListURL)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failure retrieving coingecko coins list")
-	}
-	defer r.Body.Close()
+// holderRow is a holder of the analysed token as retrieved, including holders not analysed for holding too few tokens.
+type holderRow struct {
+	Address  string `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Balance  string `json:"balance" parquet:"name=balance, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Decimals int64  `json:"decimals" parquet:"name=decimals, type=INT64"`
+}
 
-	var coinsList []coingeckoCoin
-	jsonDataFromHttp, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failure reading response body")
-	}
-	if r.StatusCode != 200 {
-		return nil, errors.Errorf("response status: %d; body: %s", r.StatusCode, string(jsonDataFromHttp))
-	}
-	if err := json.Unmarshal([]byte(jsonDataFromHttp), &coinsList); err != nil {
-		return nil, errors.Wrapf(err, "failure unmarshalling response body")
-	}
+func (r holderRow) CSVHeader() []string {
+	return []string{"address", "balance", "decimals"}
+}
 
-	if len(coinsList) == 0 {
-		return nil, errors.New("empty coingecko list")
-	}
+func (r holderRow) CSVRecord() []string {
+	return []string{r.Address, r.Balance, fmt.Sprint(r.Decimals)}
+}
 
-	return coinsList, nil
+type whaleRow struct {
+	Address        string            `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PortfolioValue float64           `json:"portfolio_value_usd" parquet:"name=portfolio_value_usd, type=DOUBLE"`
+	Chains         []whaleChainValue `json:"chains" parquet:"name=chains, repetitiontype=REPEATED"`
+	Holdings       []whaleHolding    `json:"holdings" parquet:"name=holdings, repetitiontype=REPEATED"`
 }
 
-func httpGetCoingeckoTokenInfo(tokenID string) (*tokenInfo, error) {
-retry:
-	r, err := http.Get(fmt.Sprintf(coingeckoCoinURL, tokenID))
-	if err != nil {
-		return nil, errors.Wrapf(err, "failure retrieving coingecko coin info")
-	}
-	defer r.Body.Close()
+type whaleChainValue struct {
+	Chain string  `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value float64 `json:"value_usd" parquet:"name=value_usd, type=DOUBLE"`
+}
 
-	if r.StatusCode == 429 {
-		waitTime, err := time.ParseDuration(r.Header.Get("Retry-After") + "s")
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println("coingecko rate limit reached. waiting " + r.Header.Get("Retry-After") + "s...")
-		time.Sleep(waitTime)
-		goto retry
+// whaleHolding is a token held by a whale, worth at least the min holding value.
+type whaleHolding struct {
+	Chain    string  `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Symbol   string  `json:"symbol" parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address  string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Quantity string  `json:"quantity" parquet:"name=quantity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value    float64 `json:"value_usd" parquet:"name=value_usd, type=DOUBLE"`
+	Rule     string  `json:"rule,omitempty" parquet:"name=rule, type=BYTE_ARRAY, convertedtype=UTF8"` // rule the token was excluded by
+}
+
+func (r whaleRow) CSVHeader() []string {
+	header := []string{"address", "portfolio value"}
+	for _, chain := range r.Chains {
+		header = append(header, chain.Chain)
 	}
+	return append(header, "holdings")
+}
 
-	var coinApiNativeInfo apiclient.CoingeckoCoinInfo
-	jsonDataFromHttp, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failure reading response body")
+// CSVRecord flattens holdings into a single column of "symbol (chain): value" entries.
+func (r whaleRow) CSVRecord() []string {
+	record := []string{r.Address, shortValue(decimal.NewFromFloat(r.PortfolioValue))}
+	for _, chain := range r.Chains {
+		record = append(record, shortValue(decimal.NewFromFloat(chain.Value)))
 	}
-	if err := json.Unmarshal([]byte(jsonDataFromHttp), &coinApiNativeInfo); err != nil {
-		return nil, errors.Wrapf(err, "failure unmarshalling response body")
+	holdings := make([]string, 0, len(r.Holdings))
+	for _, h := range r.Holdings {
+		holdings = append(holdings, fmt.Sprintf("%s (%s): %s", h.Symbol, h.Chain, shortValue(decimal.NewFromFloat(h.Value))))
 	}
-
-	return &tokenInfo{
-		ID:          coinApiNativeInfo.ID,
-		Symbol:      coinApiNativeInfo.Symbol,
-		GenesisDate: coinApiNativeInfo.GenesisDate,
-		MarketCap:   coinApiNativeInfo.MarketData.MarketCap.USD,
-	}, nil
+	return append(record, strings.Join(holdings, "; "))
 }
 
-func initCoingeckoTokensMap(coins coins) {
-	fmt.Printf("Initializing coingecko tokens map...\n")
+// recordedJournal journals holders' results in the checkpoint and records their balances and holdings in the database.
+type recordedJournal struct {
+	*checkpoint
+	db    *store.Store
+	runID string
+}
 
-	coinsList, err := httpGetCoingeckoTokensList()
-	if err != nil {
-		log.Fatalf("failure getting coins list: %s", err.Error())
+func (j recordedJournal) Append(result *analysis.HolderResult) error {
+	if err := j.checkpoint.Append(result); err != nil {
+		return err
 	}
+	return j.db.AddHolderResult(j.runID, result)
+}
 
-	coins.lock.Lock()
-	defer coins.lock.Unlock()
-
-	for _, chain := range cfg.Chains {
-		coins.coingeckoTokensMap[apiclient.Chain(chain)] = make(map[string]*tokenInfo)
+// recordRunStart records the run parameters and the retrieved holders in the database.
+func recordRunStart(db *store.Store, manifest *runManifest, start analysis.Start) error {
+	err := db.StartRun(store.Run{
+		ID:           manifest.RunID,
+		Command:      manifest.Command,
+		TokenAddress: tokenAddress,
+		TokenChain:   tokenChain,
+		TokenSymbol:  start.TokenSymbol,
+		Params:       manifest.Flags,
+		Blocks:       manifest.Blocks,
+		Rules:        *manifest.Rules,
+		StartedAt:    manifest.StartedAt,
+	})
+	if err != nil {
+		return err
 	}
+	return db.AddHolders(manifest.RunID, start.Holders)
+}
 
-	for _, coin := range coinsList {
-		if coin.ID == "" || coin.Symbol == "" {
-			continue
-		}
-
-		if strings.Contains(coin.ID, "wormhole") {
-			continue
-		}
-
-		tokenInfo := &tokenInfo{
-			ID:     coin.ID,
-			Symbol: coin.Symbol,
-		}
+func newTokenRow(token analysis.Token) tokenRow {
+	row := tokenRow{
+		Symbol:         token.Symbol,
+		Address:        token.Address,
+		Chain:          token.Chain,
+		Quantity:       token.Quantity.Units().StringFixed(2),
+		Value:          token.Value.InexactFloat64(),
+		Holders:        int64(token.HolderMetrics.Holders),
+		HoldersShare:   token.HolderMetrics.HoldersShare,
+		MedianPosition: token.HolderMetrics.MedianPosition,
+		MeanPosition:   token.HolderMetrics.MeanPosition,
+		Gini:           token.HolderMetrics.Gini,
+		HHI:            token.HolderMetrics.HHI,
+	}
+	if token.Info != nil {
+		row.CoingeckoID = token.Info.ID
+		row.MarketCap = token.Info.MarketCap.InexactFloat64()
+		row.GenesisDate = token.Info.GenesisDate
+	}
+	if metrics := token.PriceMetrics; metrics != nil {
+		row.Drawdown = float64Ptr(metrics.Drawdown.InexactFloat64())
+		row.Trend30d = float64Ptr(metrics.Trend30d.InexactFloat64())
+		row.Trend90d = float64Ptr(metrics.Trend90d.InexactFloat64())
+		row.DecayScore = float64Ptr(metrics.DecayScore.InexactFloat64())
+	}
+	return row
+}
 
-		if _, ok := coins.coingeckoTokensMap[apiclient.ETH]; ok && len(coin.Platforms) == 0 {
-			coins.coingeckoTokensMap[apiclient.ETH][coin.Symbol] = tokenInfo
-			continue
-		}
-		for _, chain := range cfg.Chains {
-			if _, ok := coin.Platforms[apiclient.CoingeckoPlatforms[apiclient.Chain(chain)]]; ok {
-				coins.coingeckoTokensMap[apiclient.Chain(chain)][coin.Symbol] = tokenInfo
-			}
-		}
-	}
-	if len(coins.coingeckoTokensMap) == 0 {
-		log.Fatalf("empty coingecko tokens map")
+func newSkippedTokenRow(token analysis.Token) skippedTokenRow {
+	return skippedTokenRow{
+		Symbol:  token.Symbol,
+		Address: token.Address,
+		Chain:   token.Chain,
+		Rule:    token.Rule,
+		Value:   token.Value.InexactFloat64(),
 	}
+}
 
-	fmt.Printf("Tokens per chain in coingecko: ")
-	for k, v := range coins.coingeckoTokensMap {
-		fmt.Printf("%s : %d, ", k, len(v))
+// newWhaleRow returns the whale's row with portfolio values of all configured chains.
+func newWhaleRow(w analysis.Whale) whaleRow {
+	row := whaleRow{
+		Address:        w.Address,
+		PortfolioValue: w.PortfolioValue.InexactFloat64(),
 	}
-	fmt.Println()
+	for _, chain := range cfg.Chains {
+		row.Chains = append(row.Chains, whaleChainValue{Chain: chain, Value: w.ChainsValues[chain].InexactFloat64()})
+	}
+	for _, h := range w.Holdings {
+		row.Holdings = append(row.Holdings, whaleHolding{
+			Chain:    h.Chain,
+			Symbol:   h.Symbol,
+			Address:  h.Address,
+			Quantity: h.Quantity.Units().StringFixed(2),
+			Value:    h.Value.InexactFloat64(),
+			Rule:     h.Rule,
+		})
+	}
+	return row
 }
 
-func saveFoundWhalesInAFile(whales map[string]string) {
+// saveFoundWhalesInAFile saves whales in the run directory and returns the file path.
+func saveFoundWhalesInAFile(runDir string, whales []analysis.Whale) string {
 	if len(whales) == 0 {
-		return
+		return ""
 	}
 	fmt.Printf("Found %d whales. Saving results...\n", len(whales))
 
-	filename := fmt.Sprintf("whales_%s.csv", time.Now().Format("2006-01-02"))
-
-	f, err := os.Create(fmt.Sprintf("%s/%s", resultsPathWhales, filename))
-	if err != nil {
-		log.Fatalln("failed to create file:", err)
-	}
-
-	w := csv.NewWriter(f)
-
-	err = w.Write([]string{"address", "portfolio value"})
-	if err != nil {
-		log.Fatalln("error writing headers to csv file:", err)
+	rows := make([]whaleRow, 0, len(whales))
+	for _, w := range whales {
+		rows = append(rows, newWhaleRow(w))
 	}
 
-	for k, v := range whales {
-		if err := w.Write([]string{k, v}); err != nil {
-			log.Fatalln("error writing whales list to csv file:", err)
-		}
-	}
-	w.Flush()
-
-	err = f.Close()
+	path, err := output.WriteFile(filepath.Join(runDir, "whales"), outputFormat, rows)
 	if err != nil {
-		log.Fatalln("error closing csv file:", err)
+		log.Fatalln("error writing whales list:", err)
 	}
+	return path
 }
 
-func saveFoundTokensInAFile(chain string, tokens map[string]decimal.Decimal, coins coins) {
+// saveFoundTokensInAFile saves tokens found on the chain in the run directory and returns the file path.
+func saveFoundTokensInAFile(runDir, chain string, tokens []analysis.Token, sortTokens func(rows []tokenRow)) string {
 	if len(tokens) == 0 {
 		fmt.Printf("No tokens found for this chain\n")
-		return
+		return ""
 	}
-	filename := fmt.Sprintf("tokens_%s_%s_%s.csv", tokenSymbol, chain, time.Now().Format("2006-01-02"))
-
 	fmt.Printf("Found %d tokens. Saving results...\n", len(tokens))
 
-	// sort tokens by quote quantity in descending order
-	keys := make([]string, 0, len(tokens))
-	for key := range tokens {
-		keys = append(keys, key)
+	rows := make([]tokenRow, 0, len(tokens))
+	for _, token := range tokens {
+		rows = append(rows, newTokenRow(token))
 	}
-	sort.SliceStable(keys, func(i, j int) bool {
-		return tokens[keys[i]].Cmp(tokens[keys[j]]) > 0
-	})
+	sortTokens(rows)
 
-	f, err := os.Create(fmt.Sprintf("%s/%s", resultsPathTokens, filename))
+	path, err := output.WriteFile(filepath.Join(runDir, "tokens_"+chain), outputFormat, rows)
 	if err != nil {
-		log.Fatalln("failed to create file:", err)
+		log.Fatalln("error writing tokens list:", err)
 	}
+	return path
+}
 
-	w := csv.NewWriter(f)
-
-	err = w.Write([]string{"symbol", "info"})
-	if err != nil {
-		log.Fatalln("error writing headers to csv file:", err)
+// saveHoldersInAFile saves the retrieved holders in the run directory, so that runs can be compared by holders
+// whether or not they were analysed, and returns the file path.
+func saveHoldersInAFile(runDir string, holders []class_a.Portfolio) string {
+	if len(holders) == 0 {
+		return ""
 	}
 
-	coins.lock.RLock()
-	for _, k := range keys {
-		var coinID string
-		if coinInfo, ok := coins.coingeckoTokensMap[apiclient.Chain(chain)][strings.ToLower(k)]; ok {
-			coinID = coinInfo.ID
-		}
-
-		if err := w.Write([]string{k,
-			fmt.Sprintf(coingeckoURL, coinID)}); err != nil {
-			log.Fatalln("error writing tokens list to csv file:", err)
-		}
+	rows := make([]holderRow, 0, len(holders))
+	for _, holder := range holders {
+		rows = append(rows, holderRow{
+			Address:  strings.ToLower(holder.Address),
+			Balance:  holder.Balance,
+			Decimals: int64(holder.ContractDecimals),
+		})
 	}
-	coins.lock.RUnlock()
-	w.Flush()
 
-	err = f.Close()
+	path, err := output.WriteFile(filepath.Join(runDir, "holders"), outputFormat, rows)
 	if err != nil {
-		log.Fatalln("error closing csv file:", err)
+		log.Fatalln("error writing holders list:", err)
 	}
+	return path
 }
 
-func shouldSkipBalance(balance *class_a.Portfolio) bool {
-	return balance.ContractAddress == tokenAddress || balance.Type == "dust"
-}
+func saveSkippedTokensInAFile(runDir, chain string, skipped []analysis.Token) string {
+	if len(skipped) == 0 {
+		return ""
+	}
 
-func shouldSkipHolder(holder *class_a.Portfolio) bool {
-	if holder.Address == "0x000000000000000000000000000000000000dead" {
-		return true
+	rows := make([]skippedTokenRow, 0, len(skipped))
+	for _, token := range skipped {
+		rows = append(rows, newSkippedTokenRow(token))
 	}
 
-	holderBalance, err := decimal.NewFromString(holder.Balance)
+	path, err := output.WriteFile(filepath.Join(runDir, "skipped_tokens_"+chain), outputFormat, rows)
 	if err != nil {
-		fmt.Println("got incorrect balance: " + holder.Balance)
-		return true
+		log.Fatalln("error writing skipped tokens list:", err)
 	}
-	holderBalance = holderBalance.Div(
-		decimal.NewFromInt(int64(holder.ContractDecimals)).
-			Mul(decimal.NewFromInt(10)))
-
-	return holderBalance.LessThan(decimal.NewFromInt(int64(minTokenQnt)))
+	return path
 }
 
-func shouldSkipToken(chain string, tokenSymbol string, coins coins) (bool, error) {
-	coins.lock.Lock()
-	defer coins.lock.Unlock()
-
-	tokenInfo, ok := coins.coingeckoTokensMap[apiclient.Chain(chain)][strings.ToLower(tokenSymbol)]
-	if !ok {
-		return true, nil
-	}
-
-	if tokenInfo.ID == "" {
-		return true, nil
-	}
-
-	if tokenInfo.MarketCap.Equals(decimal.Decimal{}) {
-		coinGeckoTokenInfo, err := httpGetCoingeckoTokenInfo(tokenInfo.ID)
-		if err != nil {
-			return false, errors.Wrapf(err, "failure getting coin info for coin ID: %s", tokenInfo.ID)
-		}
-
-		coins.coingeckoTokensMap[apiclient.Chain(chain)][strings.ToLower(tokenSymbol)] = coinGeckoTokenInfo
-		tokenInfo = coinGeckoTokenInfo
-
-		fmt.Printf("symbol: %s, tokenInfo: %+v\n", tokenSymbol, tokenInfo)
-	}
-
-	// TO ADD:
-	// - price metrics e.g. not an inverted exponential curve, not around ATH
-
-	if tokenInfo.MarketCap.GreaterThan(decimal.NewFromInt(50000000)) {
-		return true, nil
-	}
-
-	if tokenInfo.GenesisDate != "" {
-		genesisDate, err := time.Parse("2006-01-02", tokenInfo.GenesisDate)
-		if err != nil {
-			return false, err
+// loadRules returns rules from the --rules file, the config or the default ones, in that order.
+func loadRules() config.Rules {
+	if rulesPath != "" {
+		v := viper.New()
+		v.SetConfigFile(rulesPath)
+		if err := v.ReadInConfig(); err != nil {
+			log.Fatalf("error reading rules file %v: %v", rulesPath, err)
 		}
-		limitDate, _ := time.Parse("2006-01-02", "2022-04-01")
-		if genesisDate.Before(limitDate) {
-			return true, nil
+		var rules config.Rules
+		if err := v.Unmarshal(&rules); err != nil {
+			log.Fatalf("error unmarshalling rules: %v", err)
 		}
+		return rules
 	}
-
-	return false, nil
+	if cfg.Rules != nil {
+		return *cfg.Rules
+	}
+	return screening.DefaultRules
 }
 
 func shortValue(value decimal.Decimal) string {
@@ -510,57 +572,18 @@ func shortValue(value decimal.Decimal) string {
 	return value.Div(thousand).RoundCash(100).String() + "K"
 }
 
-func initConfig(cfgFilepath string) {
-	viper.SetConfigFile(cfgFilepath)
-
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
-	} else {
-		log.Fatalf("error using config file %v: %v", viper.ConfigFileUsed(), err)
-	}
-
-	if err := viper.GetViper().Unmarshal(&cfg); err != nil {
-		log.Fatalf("error unmarshalling config: %v", err)
-	}
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
 }
 
-func saveCoingeckoTokensList(coins coins) {
-	initCoingeckoTokensMap(coins)
-
-	coins.lock.Lock()
-	defer coins.lock.Unlock()
-
-	f, err := os.Create("tokenslist.csv")
-	if err != nil {
-		log.Fatalln("failed to create file:", err)
-	}
-
-	w := csv.NewWriter(f)
-
-	err = w.Write([]string{"id", "symbol", "marketcap", "genesis date"})
-	if err != nil {
-		log.Fatalln("error writing headers to csv file:", err)
-	}
-
-	for chain := range coins.coingeckoTokensMap {
-		for coinSymbol := range coins.coingeckoTokensMap[chain] {
-			tokenInfo := coins.coingeckoTokensMap[chain][coinSymbol]
-			coinGeckoTokenInfo, err := httpGetCoingeckoTokenInfo(tokenInfo.ID)
-			if err != nil {
-				log.Fatalf("failure getting coin info for coin ID: %s, err: %s", tokenInfo.ID, err)
-			}
-
-			if err := w.Write(coinGeckoTokenInfo.toCsvRow()); err != nil {
-				log.Fatalln("error writing token info to csv file:", err)
-			}
-			w.Flush()
-		}
+// formatOptional formats the value or returns an empty string when it is unknown.
+func formatOptional(value *float64) string {
+	if value == nil {
+		return ""
 	}
+	return formatFloat(*value)
+}
 
-	w.Flush()
-
-	err = f.Close()
-	if err != nil {
-		log.Fatalln("error closing file:", err)
-	}
+func float64Ptr(v float64) *float64 {
+	return &v
 }
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"aper/analysis"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const checkpointFileName = "checkpoint.jsonl"
+
+// checkpoint journals every processed holder's result as a line in the run directory.
+type checkpoint struct {
+	lock   *sync.Mutex
+	f      *os.File
+	runDir string
+}
+
+func checkpointPath(runDir string) string {
+	return filepath.Join(runDir, checkpointFileName)
+}
+
+func openCheckpoint(runDir string) (*checkpoint, error) {
+	f, err := os.OpenFile(checkpointPath(runDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{
+		lock:   &sync.Mutex{},
+		f:      f,
+		runDir: runDir,
+	}, nil
+}
+
+// Processed returns results journaled in the run directory so far, when the run is resumed.
+func (c *checkpoint) Processed() ([]*analysis.HolderResult, error) {
+	return readCheckpoint(c.runDir)
+}
+
+func (c *checkpoint) Append(result *analysis.HolderResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err = c.f.Write(line)
+	return err
+}
+
+func (c *checkpoint) close() {
+	if err := c.f.Close(); err != nil {
+		fmt.Printf("error closing checkpoint file: %s\n", err)
+	}
+}
+
+// readCheckpoint returns holders' results journaled in the run. A truncated last line,
+// left by a crash in the middle of a write, is ignored.
+func readCheckpoint(runDir string) ([]*analysis.HolderResult, error) {
+	f, err := os.Open(checkpointPath(runDir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var results []*analysis.HolderResult
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var result analysis.HolderResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			fmt.Printf("skipping corrupted checkpoint line: %s\n", err)
+			continue
+		}
+		results = append(results, &result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failure reading checkpoint file")
+	}
+	return results, nil
+}
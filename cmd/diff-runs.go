@@ -0,0 +1,488 @@
+package cmd
+
+import (
+	"aper/output"
+	"aper/store"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	diffRuns.PersistentFlags().StringVar(&dbPath, "db", "", "compare runs recorded in the SQLite database, runs are then given by their IDs")
+	diffRuns.PersistentFlags().Float64Var(&minChangePct, "minChange", 0, "minimal value change in percent for whales and tokens found in both runs to be reported")
+}
+
+var minChangePct float64
+
+const (
+	changeNew     = "new"
+	changeExited  = "exited"
+	changeEntered = "entered"
+	changeLeft    = "left"
+	changeGrew    = "grew"
+	changeShrank  = "shrank"
+)
+
+type holderChangeRow struct {
+	Address        string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Change         string  `json:"change" parquet:"name=change, type=BYTE_ARRAY, convertedtype=UTF8"` // new or exited
+	PortfolioValue float64 `json:"portfolio_value_usd" parquet:"name=portfolio_value_usd, type=DOUBLE"`
+}
+
+func (r holderChangeRow) CSVHeader() []string {
+	return []string{"address", "change", "portfolio value"}
+}
+
+func (r holderChangeRow) CSVRecord() []string {
+	return []string{r.Address, r.Change, formatFloat(r.PortfolioValue)}
+}
+
+type whaleChangeRow struct {
+	Address     string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Change      string  `json:"change" parquet:"name=change, type=BYTE_ARRAY, convertedtype=UTF8"` // new, exited, grew or shrank
+	ValueBefore float64 `json:"value_before_usd" parquet:"name=value_before_usd, type=DOUBLE"`
+	ValueAfter  float64 `json:"value_after_usd" parquet:"name=value_after_usd, type=DOUBLE"`
+	ValueChange float64 `json:"value_change_usd" parquet:"name=value_change_usd, type=DOUBLE"`
+}
+
+func (r whaleChangeRow) CSVHeader() []string {
+	return []string{"address", "change", "value before", "value after", "value change"}
+}
+
+func (r whaleChangeRow) CSVRecord() []string {
+	return []string{r.Address, r.Change, formatFloat(r.ValueBefore), formatFloat(r.ValueAfter), formatFloat(r.ValueChange)}
+}
+
+type tokenChangeRow struct {
+	Symbol        string  `json:"symbol" parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address       string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Chain         string  `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Change        string  `json:"change" parquet:"name=change, type=BYTE_ARRAY, convertedtype=UTF8"` // entered, left, grew or shrank
+	ValueBefore   float64 `json:"value_before_usd" parquet:"name=value_before_usd, type=DOUBLE"`
+	ValueAfter    float64 `json:"value_after_usd" parquet:"name=value_after_usd, type=DOUBLE"`
+	ValueChange   float64 `json:"value_change_usd" parquet:"name=value_change_usd, type=DOUBLE"`
+	HoldersBefore int64   `json:"holders_before" parquet:"name=holders_before, type=INT64"`
+	HoldersAfter  int64   `json:"holders_after" parquet:"name=holders_after, type=INT64"`
+}
+
+func (r tokenChangeRow) CSVHeader() []string {
+	return []string{"symbol", "address", "chain", "change", "value before", "value after", "value change",
+		"holders before", "holders after"}
+}
+
+func (r tokenChangeRow) CSVRecord() []string {
+	return []string{r.Symbol, r.Address, r.Chain, r.Change, formatFloat(r.ValueBefore), formatFloat(r.ValueAfter),
+		formatFloat(r.ValueChange), fmt.Sprint(r.HoldersBefore), fmt.Sprint(r.HoldersAfter)}
+}
+
+// runsDiff is what changed between two runs. Holders are nil when either run has no holders results.
+type runsDiff struct {
+	holders []holderChangeRow
+	whales  []whaleChangeRow
+	tokens  []tokenChangeRow
+}
+
+var diffRuns = &cobra.Command{
+	Use:   "diffRuns <older run> <newer run>",
+	Short: "Compare holders, whales and found tokens of two runs",
+	Long: "Compare holders, whales and found tokens of two runs, given by their results directories, " +
+		"their IDs in --out-dir or, with --db, their IDs in the database.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		initConfig()
+		initOutputFormat()
+
+		var db *store.Store
+		if dbPath != "" {
+			db = openStore()
+			defer db.Close()
+		}
+
+		beforeID, before := loadRun(args[0], db)
+		afterID, after := loadRun(args[1], db)
+		if err := checkSameToken(before, after); err != nil {
+			log.Fatalf("error comparing runs %v and %v: %v", beforeID, afterID, err)
+		}
+		diff := compareRuns(before, after, minChangePct)
+
+		runID, runDir := createRunDir(newRunID("diff", beforeID, afterID))
+		manifest := newRunManifest(cmd, runID, runDir)
+
+		if diff.holders != nil {
+			fmt.Printf("Holders: %d new, %d exited\n", countChanges(diff.holders, changeNew), countChanges(diff.holders, changeExited))
+			manifest.addFile(writeDiffRows(runDir, "holders_changes", diff.holders))
+		} else {
+			fmt.Printf("Holders are not compared, results of analysed holders are missing\n")
+		}
+		fmt.Printf("Whales: %d new, %d exited, %d grew, %d shrank\n",
+			countChanges(diff.whales, changeNew), countChanges(diff.whales, changeExited),
+			countChanges(diff.whales, changeGrew), countChanges(diff.whales, changeShrank))
+		manifest.addFile(writeDiffRows(runDir, "whales_changes", diff.whales))
+		fmt.Printf("Tokens: %d entered, %d left, %d grew, %d shrank\n",
+			countChanges(diff.tokens, changeEntered), countChanges(diff.tokens, changeLeft),
+			countChanges(diff.tokens, changeGrew), countChanges(diff.tokens, changeShrank))
+		manifest.addFile(writeDiffRows(runDir, "tokens_changes", diff.tokens))
+
+		manifest.finish(false)
+		return nil
+	},
+}
+
+// loadRun returns the run ID and results of the run from the database or from its results directory.
+func loadRun(run string, db *store.Store) (string, *store.RunResults) {
+	if db != nil {
+		results, err := db.RunResults(run)
+		if err != nil {
+			log.Fatalf("error reading run %v from database: %v", run, err)
+		}
+		return run, results
+	}
+
+	dir := run
+	if _, err := os.Stat(dir); err != nil {
+		dir = filepath.Join(outDir, run)
+	}
+	results, err := readRunDir(dir)
+	if err != nil {
+		log.Fatalf("error reading run %v: %v", run, err)
+	}
+	return filepath.Base(dir), results
+}
+
+// readRunDir reads results saved as csv, json or jsonl in the run directory. The analysed token is read
+// from the run manifest. Holders are the retrieved holders, as in the database, with portfolio values of
+// analysed holders read from the checkpoint, which also gives exact portfolio values of whales saved as csv.
+// Holders of runs saved without the retrieved holders are the analysed ones only.
+func readRunDir(dir string) (*store.RunResults, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	results := &store.RunResults{
+		Whales: make(map[string]float64),
+	}
+
+	manifest, err := readRunManifest(dir)
+	switch {
+	case err == nil:
+		results.TokenAddress = strings.ToLower(manifest.Flags["tokenAddress"])
+		results.TokenChain = manifest.Flags["tokenChain"]
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	holdersFiles, err := filepath.Glob(filepath.Join(dir, "holders.*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range holdersFiles {
+		rows, err := readResultRows(path, holderRowFromCSV)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failure reading %s", filepath.Base(path))
+		}
+		results.Holders = make(map[string]float64, len(rows))
+		for _, row := range rows {
+			results.Holders[strings.ToLower(row.Address)] = 0
+		}
+	}
+
+	analysed := make(map[string]float64) // analysed holder address to portfolio value
+	holderResults, err := readCheckpoint(dir)
+	switch {
+	case err == nil:
+		if results.Holders == nil {
+			results.Holders = make(map[string]float64, len(holderResults))
+		}
+		for _, result := range holderResults {
+			value := decimal.NewFromInt(0)
+			for _, chainResult := range result.Chains {
+				value = value.Add(chainResult.PortfolioValue)
+			}
+			address := strings.ToLower(result.Address)
+			analysed[address] = value.InexactFloat64()
+			results.Holders[address] = analysed[address]
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	tokensFiles, err := filepath.Glob(filepath.Join(dir, "tokens_*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range tokensFiles {
+		rows, err := readResultRows(path, tokenRowFromCSV)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failure reading %s", filepath.Base(path))
+		}
+		for _, row := range rows {
+			results.Tokens = append(results.Tokens, store.FoundToken{
+				Chain:   row.Chain,
+				Address: strings.ToLower(row.Address),
+				Symbol:  row.Symbol,
+				Value:   row.Value,
+				Holders: int(row.Holders),
+			})
+		}
+	}
+
+	whalesFiles, err := filepath.Glob(filepath.Join(dir, "whales.*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range whalesFiles {
+		rows, err := readResultRows(path, whaleRowFromCSV)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failure reading %s", filepath.Base(path))
+		}
+		for _, row := range rows {
+			address := strings.ToLower(row.Address)
+			value := row.PortfolioValue
+			if exact, ok := analysed[address]; ok {
+				value = exact
+			}
+			results.Whales[address] = value
+		}
+	}
+	return results, nil
+}
+
+// readResultRows reads rows of a results file by its extension, csv records are converted by fromCSV.
+func readResultRows[T any](path string, fromCSV func(record map[string]string) (T, error)) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl":
+		return readJSONRows[T](f)
+	case ".csv":
+	default:
+		return nil, errors.Errorf("unsupported results format %s, runs saved as csv, json or jsonl can be compared", filepath.Ext(path))
+	}
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure parsing csv file")
+	}
+	var rows []T
+	for i := 1; i < len(records); i++ {
+		record := make(map[string]string, len(records[0]))
+		for j, column := range records[0] {
+			if j < len(records[i]) {
+				record[column] = records[i][j]
+			}
+		}
+		row, err := fromCSV(record)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failure parsing line %d", i+1)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func tokenRowFromCSV(record map[string]string) (tokenRow, error) {
+	row := tokenRow{
+		Symbol:  record["symbol"],
+		Address: record["address"],
+		Chain:   record["chain"],
+	}
+	var err error
+	if row.Value, err = strconv.ParseFloat(record["value"], 64); err != nil {
+		return row, errors.Wrapf(err, "incorrect value")
+	}
+	if row.Holders, err = strconv.ParseInt(record["holders"], 10, 64); err != nil {
+		return row, errors.Wrapf(err, "incorrect holders count")
+	}
+	return row, nil
+}
+
+func holderRowFromCSV(record map[string]string) (holderRow, error) {
+	decimals, err := strconv.ParseInt(record["decimals"], 10, 64)
+	if err != nil {
+		return holderRow{}, errors.Wrapf(err, "incorrect decimals")
+	}
+	return holderRow{Address: record["address"], Balance: record["balance"], Decimals: decimals}, nil
+}
+
+func whaleRowFromCSV(record map[string]string) (whaleRow, error) {
+	value, err := parseShortValue(record["portfolio value"])
+	if err != nil {
+		return whaleRow{}, errors.Wrapf(err, "incorrect portfolio value")
+	}
+	return whaleRow{Address: record["address"], PortfolioValue: value}, nil
+}
+
+// parseShortValue parses values formatted by shortValue, like 216K or 1.5M.
+func parseShortValue(s string) (float64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1000000
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1000
+	}
+	value, err := strconv.ParseFloat(strings.TrimRight(s, "MK"), 64)
+	return value * multiplier, err
+}
+
+// checkSameToken returns an error unless both runs are known to be runs of the same token.
+func checkSameToken(before, after *store.RunResults) error {
+	if before.TokenAddress == "" || after.TokenAddress == "" {
+		return errors.New("analysed token of the run is unknown, run.json is missing or was not saved by balancesOfTokensHolders")
+	}
+	if before.TokenAddress != after.TokenAddress || before.TokenChain != after.TokenChain {
+		return errors.Errorf("runs of different tokens, %s on %s and %s on %s",
+			before.TokenAddress, before.TokenChain, after.TokenAddress, after.TokenChain)
+	}
+	return nil
+}
+
+// compareRuns returns changes from the before run to the after run. Whales and tokens found in both runs
+// are reported when their value changed by at least minChangePct percent.
+func compareRuns(before, after *store.RunResults, minChangePct float64) runsDiff {
+	var diff runsDiff
+
+	if before.Holders != nil && after.Holders != nil {
+		diff.holders = []holderChangeRow{}
+		for address, value := range after.Holders {
+			if _, ok := before.Holders[address]; !ok {
+				diff.holders = append(diff.holders, holderChangeRow{Address: address, Change: changeNew, PortfolioValue: value})
+			}
+		}
+		for address, value := range before.Holders {
+			if _, ok := after.Holders[address]; !ok {
+				diff.holders = append(diff.holders, holderChangeRow{Address: address, Change: changeExited, PortfolioValue: value})
+			}
+		}
+		sort.Slice(diff.holders, func(i, j int) bool {
+			if diff.holders[i].PortfolioValue != diff.holders[j].PortfolioValue {
+				return diff.holders[i].PortfolioValue > diff.holders[j].PortfolioValue
+			}
+			return diff.holders[i].Address < diff.holders[j].Address
+		})
+	}
+
+	for address, valueAfter := range after.Whales {
+		valueBefore, ok := before.Whales[address]
+		change := changeNew
+		if ok {
+			change = valueChange(valueBefore, valueAfter, minChangePct)
+			if change == "" {
+				continue
+			}
+		}
+		diff.whales = append(diff.whales, whaleChangeRow{Address: address, Change: change,
+			ValueBefore: valueBefore, ValueAfter: valueAfter, ValueChange: valueAfter - valueBefore})
+	}
+	for address, valueBefore := range before.Whales {
+		if _, ok := after.Whales[address]; !ok {
+			diff.whales = append(diff.whales, whaleChangeRow{Address: address, Change: changeExited,
+				ValueBefore: valueBefore, ValueChange: -valueBefore})
+		}
+	}
+	sort.Slice(diff.whales, func(i, j int) bool {
+		if a, b := math.Abs(diff.whales[i].ValueChange), math.Abs(diff.whales[j].ValueChange); a != b {
+			return a > b
+		}
+		return diff.whales[i].Address < diff.whales[j].Address
+	})
+
+	tokensBefore := make(map[string]store.FoundToken, len(before.Tokens))
+	for _, token := range before.Tokens {
+		tokensBefore[token.Chain+":"+token.Address] = token
+	}
+	tokensAfter := make(map[string]bool, len(after.Tokens))
+	for _, token := range after.Tokens {
+		tokensAfter[token.Chain+":"+token.Address] = true
+		row := tokenChangeRow{Symbol: token.Symbol, Address: token.Address, Chain: token.Chain, Change: changeEntered,
+			ValueAfter: token.Value, ValueChange: token.Value, HoldersAfter: int64(token.Holders)}
+		if tokenBefore, ok := tokensBefore[token.Chain+":"+token.Address]; ok {
+			row.Change = valueChange(tokenBefore.Value, token.Value, minChangePct)
+			if row.Change == "" {
+				continue
+			}
+			row.ValueBefore = tokenBefore.Value
+			row.ValueChange = token.Value - tokenBefore.Value
+			row.HoldersBefore = int64(tokenBefore.Holders)
+		}
+		diff.tokens = append(diff.tokens, row)
+	}
+	for _, token := range before.Tokens {
+		if tokensAfter[token.Chain+":"+token.Address] {
+			continue
+		}
+		diff.tokens = append(diff.tokens, tokenChangeRow{Symbol: token.Symbol, Address: token.Address, Chain: token.Chain,
+			Change: changeLeft, ValueBefore: token.Value, ValueChange: -token.Value, HoldersBefore: int64(token.Holders)})
+	}
+	sort.Slice(diff.tokens, func(i, j int) bool {
+		if a, b := math.Abs(diff.tokens[i].ValueChange), math.Abs(diff.tokens[j].ValueChange); a != b {
+			return a > b
+		}
+		return diff.tokens[i].Chain+diff.tokens[i].Address < diff.tokens[j].Chain+diff.tokens[j].Address
+	})
+
+	return diff
+}
+
+// valueChange returns grew or shrank, or an empty string when the value changed by less than minChangePct percent.
+func valueChange(before, after, minChangePct float64) string {
+	if before == after {
+		return ""
+	}
+	if before != 0 && math.Abs(after-before)/math.Abs(before)*100 < minChangePct {
+		return ""
+	}
+	if after > before {
+		return changeGrew
+	}
+	return changeShrank
+}
+
+type changeRow interface {
+	holderChangeRow | whaleChangeRow | tokenChangeRow
+}
+
+func countChanges[T changeRow](rows []T, change string) int {
+	var count int
+	for _, row := range rows {
+		var c string
+		switch r := any(row).(type) {
+		case holderChangeRow:
+			c = r.Change
+		case whaleChangeRow:
+			c = r.Change
+		case tokenChangeRow:
+			c = r.Change
+		}
+		if c == change {
+			count++
+		}
+	}
+	return count
+}
+
+// writeDiffRows saves the rows in the run directory and returns the file path, or an empty string when there are no rows.
+func writeDiffRows[T output.Row](runDir, name string, rows []T) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	path, err := output.WriteFile(filepath.Join(runDir, name), outputFormat, rows)
+	if err != nil {
+		log.Fatalf("error writing %v: %v", name, err)
+	}
+	return path
+}
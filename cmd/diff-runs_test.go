@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"aper/store"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareRuns(t *testing.T) {
+	before := &store.RunResults{
+		Holders: map[string]float64{"0xa": 300000, "0xb": 1000, "0xc": 500},
+		Whales:  map[string]float64{"0xa": 300000, "0xw": 100000},
+		Tokens: []store.FoundToken{
+			{Chain: "ETHEREUM", Address: "0xf", Symbol: "F", Value: 1000, Holders: 2},
+			{Chain: "ETHEREUM", Address: "0xg", Symbol: "G", Value: 1000, Holders: 2},
+			{Chain: "ETHEREUM", Address: "0xh", Symbol: "H", Value: 1000, Holders: 1},
+		},
+	}
+	after := &store.RunResults{
+		Holders: map[string]float64{"0xa": 400000, "0xb": 1000, "0xd": 2000},
+		Whales:  map[string]float64{"0xa": 400000, "0xd": 200000},
+		Tokens: []store.FoundToken{
+			{Chain: "ETHEREUM", Address: "0xf", Symbol: "F", Value: 500, Holders: 1},
+			{Chain: "ETHEREUM", Address: "0xg", Symbol: "G", Value: 1010, Holders: 2},
+			{Chain: "ARBITRUM", Address: "0xh", Symbol: "H", Value: 3000, Holders: 3},
+		},
+	}
+
+	diff := compareRuns(before, after, 5)
+
+	wantHolders := []holderChangeRow{
+		{Address: "0xd", Change: changeNew, PortfolioValue: 2000},
+		{Address: "0xc", Change: changeExited, PortfolioValue: 500},
+	}
+	if len(diff.holders) != len(wantHolders) {
+		t.Fatalf("got holders changes %+v, want %+v", diff.holders, wantHolders)
+	}
+	for i := range wantHolders {
+		if diff.holders[i] != wantHolders[i] {
+			t.Errorf("got holders change %+v, want %+v", diff.holders[i], wantHolders[i])
+		}
+	}
+
+	wantWhales := []whaleChangeRow{
+		{Address: "0xd", Change: changeNew, ValueAfter: 200000, ValueChange: 200000},
+		{Address: "0xa", Change: changeGrew, ValueBefore: 300000, ValueAfter: 400000, ValueChange: 100000},
+		{Address: "0xw", Change: changeExited, ValueBefore: 100000, ValueChange: -100000},
+	}
+	if len(diff.whales) != len(wantWhales) {
+		t.Fatalf("got whales changes %+v, want %+v", diff.whales, wantWhales)
+	}
+	for i := range wantWhales {
+		if diff.whales[i] != wantWhales[i] {
+			t.Errorf("got whales change %+v, want %+v", diff.whales[i], wantWhales[i])
+		}
+	}
+
+	// G changed by less than 5% and H moved to another chain
+	wantTokens := []tokenChangeRow{
+		{Symbol: "H", Address: "0xh", Chain: "ARBITRUM", Change: changeEntered, ValueAfter: 3000, ValueChange: 3000, HoldersAfter: 3},
+		{Symbol: "H", Address: "0xh", Chain: "ETHEREUM", Change: changeLeft, ValueBefore: 1000, ValueChange: -1000, HoldersBefore: 1},
+		{Symbol: "F", Address: "0xf", Chain: "ETHEREUM", Change: changeShrank, ValueBefore: 1000, ValueAfter: 500, ValueChange: -500,
+			HoldersBefore: 2, HoldersAfter: 1},
+	}
+	if len(diff.tokens) != len(wantTokens) {
+		t.Fatalf("got tokens changes %+v, want %+v", diff.tokens, wantTokens)
+	}
+	for i := range wantTokens {
+		if diff.tokens[i] != wantTokens[i] {
+			t.Errorf("got tokens change %+v, want %+v", diff.tokens[i], wantTokens[i])
+		}
+	}
+}
+
+func TestCompareRunsWithoutHolders(t *testing.T) {
+	before := &store.RunResults{Whales: map[string]float64{}}
+	after := &store.RunResults{Holders: map[string]float64{"0xa": 1}, Whales: map[string]float64{}}
+	if diff := compareRuns(before, after, 0); diff.holders != nil {
+		t.Errorf("got holders changes %+v, want holders not compared", diff.holders)
+	}
+}
+
+func TestReadRunDir(t *testing.T) {
+	results, err := readRunDir(filepath.Join("testdata", "golden", "balances-of-tokens-holders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a checkpoint, retrieved holders are known but not their portfolio values
+	if len(results.Holders) != 5 || results.Holders["0x000000000000000000000000000000000000dead"] != 0 {
+		t.Errorf("got holders %v, want the 5 retrieved holders", results.Holders)
+	}
+	if len(results.Tokens) != 2 {
+		t.Fatalf("got tokens %+v, want GOOD on ARBITRUM and ETHEREUM", results.Tokens)
+	}
+	for _, token := range results.Tokens {
+		if token.Symbol != "GOOD" || token.Value == 0 || token.Holders == 0 {
+			t.Errorf("got token %+v", token)
+		}
+	}
+	if value := results.Whales["0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"]; len(results.Whales) != 1 || value != 216000 {
+		t.Errorf("got whales %v, want 0xaaa… worth 216K", results.Whales)
+	}
+}
+
+func TestParseShortValue(t *testing.T) {
+	for s, want := range map[string]float64{"216K": 216000, "1.5M": 1500000, "0.25K": 250} {
+		got, err := parseShortValue(s)
+		if err != nil || got != want {
+			t.Errorf("parseShortValue(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+}
+
+func TestReadRunDirToken(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"command":"balancesOfTokensHolders","flags":{"tokenAddress":"0xABCD","tokenChain":"ETHEREUM"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, manifestFileName), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := readRunDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.TokenAddress != "0xabcd" || results.TokenChain != "ETHEREUM" {
+		t.Errorf("got token %s on %s, want 0xabcd on ETHEREUM", results.TokenAddress, results.TokenChain)
+	}
+}
+
+func TestCheckSameToken(t *testing.T) {
+	tests := []struct {
+		desc          string
+		before, after store.RunResults
+		wantErr       bool
+	}{
+		{desc: "same token", before: store.RunResults{TokenAddress: "0x1", TokenChain: "ETHEREUM"},
+			after: store.RunResults{TokenAddress: "0x1", TokenChain: "ETHEREUM"}},
+		{desc: "other token", before: store.RunResults{TokenAddress: "0x1", TokenChain: "ETHEREUM"},
+			after: store.RunResults{TokenAddress: "0x2", TokenChain: "ETHEREUM"}, wantErr: true},
+		{desc: "other chain", before: store.RunResults{TokenAddress: "0x1", TokenChain: "ETHEREUM"},
+			after: store.RunResults{TokenAddress: "0x1", TokenChain: "ARBITRUM"}, wantErr: true},
+		{desc: "unknown token", before: store.RunResults{},
+			after: store.RunResults{TokenAddress: "0x1", TokenChain: "ETHEREUM"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := checkSameToken(&tc.before, &tc.after)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	apiclient "aper/api-client"
+	"aper/store"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// newCoingeckoStandIn serves recorded CoinGecko responses from testdata/coingecko.
+func newCoingeckoStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/coins/")
+		var fixture string
+		switch {
+		case path == "list":
+			fixture = "coins-list.json"
+		case strings.HasSuffix(path, "/market_chart"):
+			fixture = filepath.Join("market-chart", strings.TrimSuffix(path, "/market_chart")+".json")
+		default:
+			fixture = filepath.Join("coins", path+".json")
+		}
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "coingecko", fixture))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// useFakeAPIClient makes commands use the fake API client with responses from testdata/covalent.json.
+func useFakeAPIClient(t *testing.T) {
+	t.Helper()
+	fake, err := apiclient.NewFakeAPIClientFromFile(filepath.Join("testdata", "covalent.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := newAPIClient
+	newAPIClient = func(cache *apiclient.Cache) apiclient.APIClienter {
+		return fake
+	}
+	t.Cleanup(func() {
+		newAPIClient = original
+	})
+}
+
+func writeTestConfig(t *testing.T, dir, coingeckoURL string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf(`chains:
+  - ETHEREUM
+  - ARBITRUM
+coingeckoUrl: %s
+rateLimits:
+  coingecko: 1000
+rules:
+  marketCap:
+    max: 50000000
+  genesisDate:
+    from: "2022-04-01"
+`, coingeckoURL)
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runDirOf returns the only run directory created in outDir.
+func runDirOf(t *testing.T, outDir string) string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single run directory in %s, got %d entries", outDir, len(entries))
+	}
+	return filepath.Join(outDir, entries[0].Name())
+}
+
+// compareWithGolden compares CSV files of the run directory with files of the golden directory.
+func compareWithGolden(t *testing.T, runDir, goldenDir string) {
+	t.Helper()
+	produced, err := filepath.Glob(filepath.Join(runDir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range produced {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(goldenDir, filepath.Base(path)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return
+	}
+
+	golden, err := filepath.Glob(filepath.Join(goldenDir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(produced) != len(golden) {
+		t.Errorf("produced %d files, expected %d", len(produced), len(golden))
+	}
+	for _, goldenPath := range golden {
+		want, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(runDir, filepath.Base(goldenPath)))
+		if err != nil {
+			t.Errorf("missing file %s: %v", filepath.Base(goldenPath), err)
+			continue
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s differs from golden file:\ngot:\n%s\nwant:\n%s", filepath.Base(goldenPath), got, want)
+		}
+	}
+}
+
+func TestBalancesOfTokensHoldersEndToEnd(t *testing.T) {
+	useFakeAPIClient(t)
+	coingecko := newCoingeckoStandIn(t)
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "results")
+
+	rootCmd.SetArgs([]string{
+		"balancesOfTokensHolders",
+		"--config", writeTestConfig(t, dir, coingecko.URL),
+		"--no-cache",
+		"--out-dir", outDir,
+		"--concurrency", "2",
+		"--tokenAddress", "0x1111111111111111111111111111111111111111",
+		"--tokenChain", "ETHEREUM",
+		"--minTokenQnt", "100",
+		"--minHoldingUSDValue", "100",
+		"--whaleThreshold", "100000",
+	})
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	compareWithGolden(t, runDirOf(t, outDir), filepath.Join("testdata", "golden", "balances-of-tokens-holders"))
+}
+
+func TestBalancesOfTokensHoldersRecordsInDatabase(t *testing.T) {
+	useFakeAPIClient(t)
+	coingecko := newCoingeckoStandIn(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aper.db")
+	t.Cleanup(func() {
+		dbPath = ""
+	})
+
+	rootCmd.SetArgs([]string{
+		"balancesOfTokensHolders",
+		"--config", writeTestConfig(t, dir, coingecko.URL),
+		"--no-cache",
+		"--out-dir", filepath.Join(dir, "results"),
+		"--db", path,
+		"--tokenAddress", "0x1111111111111111111111111111111111111111",
+		"--tokenChain", "ETHEREUM",
+		"--minTokenQnt", "100",
+		"--minHoldingUSDValue", "100",
+		"--whaleThreshold", "100000",
+	})
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	runs, err := db.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(runs))
+	}
+	run := runs[0]
+	if run.TokenSymbol != "APE" || run.Holders != 5 || run.AnalysedHolders != 3 || run.FoundTokens != 2 || run.FinishedAt == nil {
+		t.Errorf("got run %+v, want APE with 5 holders, 3 analysed, 2 found tokens", run)
+	}
+
+	tokens, err := db.CommonTokens(time.Time{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].Symbol != "GOOD" {
+		t.Errorf("got tokens %+v, want GOOD on both chains", tokens)
+	}
+}
+
+// balancesRecorder records addresses whose balances are retrieved.
+type balancesRecorder struct {
+	apiclient.APIClienter
+	lock      sync.Mutex
+	addresses map[string]bool
+}
+
+func (c *balancesRecorder) GetAddressBalances(ctx context.Context, req apiclient.GetAddressBalancesReq) ([]class_a.Portfolio, error) {
+	c.lock.Lock()
+	c.addresses[strings.ToLower(req.Address)] = true
+	c.lock.Unlock()
+	return c.APIClienter.GetAddressBalances(ctx, req)
+}
+
+func TestBalancesOfTokensHoldersResume(t *testing.T) {
+	useFakeAPIClient(t)
+	coingecko := newCoingeckoStandIn(t)
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "results")
+	args := []string{
+		"balancesOfTokensHolders",
+		"--config", writeTestConfig(t, dir, coingecko.URL),
+		"--no-cache",
+		"--out-dir", outDir,
+		"--tokenAddress", "0x1111111111111111111111111111111111111111",
+		"--tokenChain", "ETHEREUM",
+		"--minTokenQnt", "100",
+		"--minHoldingUSDValue", "100",
+		"--whaleThreshold", "100000",
+	}
+	rootCmd.SetArgs(args)
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	runDir := runDirOf(t, outDir)
+
+	// keep the first holders in the checkpoint as if the run had been interrupted
+	results, err := readCheckpoint(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d journaled holders, want 3", len(results))
+	}
+	data, err := ioutil.ReadFile(checkpointPath(runDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := ioutil.WriteFile(checkpointPath(runDir), []byte(strings.Join(lines[:2], "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"tokens_ETHEREUM.csv", "tokens_ARBITRUM.csv", "skipped_tokens_ETHEREUM.csv", "whales.csv"} {
+		if err := os.Remove(filepath.Join(runDir, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := &balancesRecorder{APIClienter: newAPIClient(nil), addresses: make(map[string]bool)}
+	newAPIClient = func(cache *apiclient.Cache) apiclient.APIClienter {
+		return recorder
+	}
+	t.Cleanup(func() {
+		resumeRunID = ""
+	})
+	rootCmd.SetArgs(append(args, "--resume", filepath.Base(runDir)))
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, journaled := range results[:2] {
+		if recorder.addresses[strings.ToLower(journaled.Address)] {
+			t.Errorf("balances of %s retrieved again, want the journaled holder skipped", journaled.Address)
+		}
+	}
+	if !recorder.addresses[strings.ToLower(results[2].Address)] {
+		t.Errorf("balances of %s not retrieved, want the holder missing from the checkpoint processed", results[2].Address)
+	}
+	// aggregates rebuilt from the checkpoint match the uninterrupted run
+	compareWithGolden(t, runDir, filepath.Join("testdata", "golden", "balances-of-tokens-holders"))
+}
+
+func TestDiffRunsHoldersInFilesAndDatabase(t *testing.T) {
+	useFakeAPIClient(t)
+	coingecko := newCoingeckoStandIn(t)
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "results")
+	path := filepath.Join(dir, "aper.db")
+	t.Cleanup(func() {
+		dbPath = ""
+		maxHolders = 0
+	})
+	args := []string{
+		"balancesOfTokensHolders",
+		"--config", writeTestConfig(t, dir, coingecko.URL),
+		"--no-cache",
+		"--out-dir", outDir,
+		"--db", path,
+		"--tokenAddress", "0x1111111111111111111111111111111111111111",
+		"--tokenChain", "ETHEREUM",
+		"--minHoldingUSDValue", "100",
+		"--whaleThreshold", "100000",
+	}
+	rootCmd.SetArgs(append(args, "--minTokenQnt", "100", "--maxHolders", "0"))
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	beforeDir := runDirOf(t, outDir)
+
+	// 0xddd… still holds the token but too few to be analysed, 0xccc… and the dead address are not retrieved
+	rootCmd.SetArgs(append(args, "--minTokenQnt", "300", "--maxHolders", "3"))
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var afterDir string
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(beforeDir) {
+			afterDir = filepath.Join(outDir, entry.Name())
+		}
+	}
+
+	_, beforeFiles := loadRun(beforeDir, nil)
+	_, afterFiles := loadRun(afterDir, nil)
+	filesDiff := compareRuns(beforeFiles, afterFiles, 0)
+
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, beforeDB := loadRun(filepath.Base(beforeDir), db)
+	_, afterDB := loadRun(filepath.Base(afterDir), db)
+	dbDiff := compareRuns(beforeDB, afterDB, 0)
+
+	wantHolders := []holderChangeRow{
+		{Address: "0x000000000000000000000000000000000000dead", Change: changeExited},
+		{Address: "0xcccccccccccccccccccccccccccccccccccccccc", Change: changeExited},
+	}
+	for mode, holders := range map[string][]holderChangeRow{"files": filesDiff.holders, "database": dbDiff.holders} {
+		if len(holders) != len(wantHolders) {
+			t.Errorf("%s: got holders changes %+v, want %+v", mode, holders, wantHolders)
+			continue
+		}
+		for i := range wantHolders {
+			if holders[i] != wantHolders[i] {
+				t.Errorf("%s: got holders change %+v, want %+v", mode, holders[i], wantHolders[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"aper/analysis"
+	apiclient "aper/api-client"
+	"aper/output"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	holderOverlap.PersistentFlags().StringArrayVar(&overlapTokenAddresses, "tokenAddress", nil, "token address, repeat for every compared token")
+	_ = holderOverlap.MarkPersistentFlagRequired("tokenAddress")
+
+	holderOverlap.PersistentFlags().StringArrayVar(&overlapTokenChains, "tokenChain", nil, "chain of the token address at the same position, a single chain applies to all tokens")
+	_ = holderOverlap.MarkPersistentFlagRequired("tokenChain")
+
+	holderOverlap.PersistentFlags().IntVar(&minTokenQnt, "minTokenQnt", 100, "holders with less tokens are not compared")
+	holderOverlap.PersistentFlags().IntVar(&maxHolders, "maxHolders", 0, "stop after retrieving that many holders of each token, 0 means all")
+	holderOverlap.PersistentFlags().StringVar(&overlapWhaleThresholdStr, "whaleThreshold", "", "portfolio value of whales among shared holders, whales are not counted when empty")
+}
+
+var (
+	overlapTokenAddresses    []string
+	overlapTokenChains       []string
+	overlapWhaleThresholdStr string
+)
+
+type overlapToken struct {
+	symbol  string
+	label   string          // symbol and chain
+	holders map[string]bool // lowercase addresses
+}
+
+type overlapRow struct {
+	Tokens          string  `json:"tokens" parquet:"name=tokens, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TokensCount     int64   `json:"tokens_count" parquet:"name=tokens_count, type=INT64"`
+	UnionHolders    int64   `json:"union_holders" parquet:"name=union_holders, type=INT64"`
+	SharedHolders   int64   `json:"shared_holders" parquet:"name=shared_holders, type=INT64"`
+	Jaccard         float64 `json:"jaccard" parquet:"name=jaccard, type=DOUBLE"`
+	SharedWhales    int64   `json:"shared_whales" parquet:"name=shared_whales, type=INT64"`
+	WhalesCounted   bool    `json:"whales_counted" parquet:"name=whales_counted, type=BOOLEAN"`
+	HoldersPerToken string  `json:"holders_per_token" parquet:"name=holders_per_token, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func (r overlapRow) CSVHeader() []string {
+	return []string{"tokens", "tokens count", "holders per token", "union holders", "shared holders", "jaccard", "shared whales"}
+}
+
+func (r overlapRow) CSVRecord() []string {
+	sharedWhales := ""
+	if r.WhalesCounted {
+		sharedWhales = fmt.Sprint(r.SharedWhales)
+	}
+	return []string{r.Tokens, fmt.Sprint(r.TokensCount), r.HoldersPerToken, fmt.Sprint(r.UnionHolders),
+		fmt.Sprint(r.SharedHolders), strconv.FormatFloat(r.Jaccard, 'f', 4, 64), sharedWhales}
+}
+
+// sharedAddressRow is a holder of at least two of the compared tokens.
+type sharedAddressRow struct {
+	Address        string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Tokens         string  `json:"tokens" parquet:"name=tokens, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TokensCount    int64   `json:"tokens_count" parquet:"name=tokens_count, type=INT64"`
+	PortfolioValue float64 `json:"portfolio_value_usd" parquet:"name=portfolio_value_usd, type=DOUBLE"`
+	Whale          bool    `json:"whale" parquet:"name=whale, type=BOOLEAN"`
+}
+
+func (r sharedAddressRow) CSVHeader() []string {
+	return []string{"address", "tokens", "tokens count", "portfolio value", "whale"}
+}
+
+func (r sharedAddressRow) CSVRecord() []string {
+	return []string{r.Address, r.Tokens, fmt.Sprint(r.TokensCount), formatFloat(r.PortfolioValue), fmt.Sprint(r.Whale)}
+}
+
+var holderOverlap = &cobra.Command{
+	Use:   "holderOverlap",
+	Short: "Compare holders of two or more tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		initConfig()
+		initOutputFormat()
+		ctx := cmd.Context()
+
+		if len(overlapTokenAddresses) < 2 {
+			log.Fatal("at least two tokens are needed to compare holders")
+		}
+		chains := overlapTokenChains
+		if len(chains) == 1 {
+			for len(chains) < len(overlapTokenAddresses) {
+				chains = append(chains, overlapTokenChains[0])
+			}
+		}
+		if len(chains) != len(overlapTokenAddresses) {
+			log.Fatalf("got %d token addresses and %d token chains", len(overlapTokenAddresses), len(chains))
+		}
+
+		var whaleThreshold *decimal.Decimal
+		if overlapWhaleThresholdStr != "" {
+			threshold, err := decimal.NewFromString(overlapWhaleThresholdStr)
+			if err != nil {
+				log.Fatalf("error parsing whale threshold %v: %v", overlapWhaleThresholdStr, err)
+			}
+			whaleThreshold = &threshold
+		}
+
+		apiClient := newAPIClient(initCache())
+
+		tokens := make([]overlapToken, 0, len(overlapTokenAddresses))
+		for i, address := range overlapTokenAddresses {
+			fmt.Printf("Retrieving holders of %s on %s...\n", address, chains[i])
+			token, err := overlapTokenHolders(ctx, apiClient, address, chains[i])
+			if err != nil {
+				log.Fatalf("error retrieving token holders for address %v: %v", address, err)
+			}
+			fmt.Printf("Found %d holders of %s\n", len(token.holders), token.label)
+			tokens = append(tokens, token)
+		}
+
+		shared := sharedHolders(tokens)
+		fmt.Printf("Found %d addresses holding at least two of the tokens\n", len(shared))
+
+		portfolioValues := make(map[string]decimal.Decimal)
+		if whaleThreshold != nil {
+			portfolioValues = sharedHoldersPortfolioValues(ctx, apiClient, shared)
+		}
+		isWhale := func(address string) bool {
+			value, ok := portfolioValues[address]
+			return ok && whaleThreshold != nil && !value.LessThan(*whaleThreshold)
+		}
+
+		var rows []overlapRow
+		for i := 0; i < len(tokens); i++ {
+			for j := i + 1; j < len(tokens); j++ {
+				rows = append(rows, overlapOf([]overlapToken{tokens[i], tokens[j]}, isWhale, whaleThreshold != nil))
+			}
+		}
+		if len(tokens) > 2 {
+			rows = append(rows, overlapOf(tokens, isWhale, whaleThreshold != nil))
+		}
+
+		sharedRows := make([]sharedAddressRow, 0, len(shared))
+		for address, labels := range shared {
+			sharedRows = append(sharedRows, sharedAddressRow{
+				Address:        address,
+				Tokens:         strings.Join(labels, ", "),
+				TokensCount:    int64(len(labels)),
+				PortfolioValue: portfolioValues[address].InexactFloat64(),
+				Whale:          isWhale(address),
+			})
+		}
+		sort.SliceStable(sharedRows, func(i, j int) bool {
+			if sharedRows[i].TokensCount != sharedRows[j].TokensCount {
+				return sharedRows[i].TokensCount > sharedRows[j].TokensCount
+			}
+			return sharedRows[i].PortfolioValue > sharedRows[j].PortfolioValue
+		})
+
+		symbols := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			symbols = append(symbols, token.symbol)
+		}
+		runID, runDir := createRunDir(newRunID("overlap", strings.Join(symbols, "-")))
+		manifest := newRunManifest(cmd, runID, runDir)
+
+		path, err := output.WriteFile(filepath.Join(runDir, "overlap"), outputFormat, rows)
+		if err != nil {
+			log.Fatalln("error writing overlap:", err)
+		}
+		manifest.addFile(path)
+		if len(sharedRows) > 0 {
+			path, err := output.WriteFile(filepath.Join(runDir, "shared_holders"), outputFormat, sharedRows)
+			if err != nil {
+				log.Fatalln("error writing shared holders:", err)
+			}
+			manifest.addFile(path)
+		}
+		manifest.finish(ctx.Err() != nil)
+		return ctx.Err()
+	},
+}
+
+func overlapTokenHolders(ctx context.Context, apiClient apiclient.APIClienter, tokenAddress, chain string) (overlapToken, error) {
+	holders, err := apiClient.GetTokenHolders(ctx, apiclient.GetTokenHoldersReq{
+		Chain:        apiclient.Chain(chain),
+		TokenAddress: tokenAddress,
+		MaxHolders:   maxHolders,
+	})
+	if err != nil {
+		return overlapToken{}, err
+	}
+
+	token := overlapToken{
+		symbol:  tokenAddress,
+		holders: make(map[string]bool, len(holders)),
+	}
+	if len(holders) > 0 && holders[0].ContractTickerSymbol != "" {
+		token.symbol = holders[0].ContractTickerSymbol
+	}
+	token.label = fmt.Sprintf("%s (%s)", token.symbol, chain)
+	for _, holder := range holders {
+		skip, err := analysis.ShouldSkipHolder(&holder, minTokenQnt)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if skip {
+			continue
+		}
+		token.holders[strings.ToLower(holder.Address)] = true
+	}
+	return token, nil
+}
+
+// sharedHolders returns addresses holding at least two of the tokens, mapped to labels of tokens they hold.
+func sharedHolders(tokens []overlapToken) map[string][]string {
+	held := make(map[string][]string)
+	for _, token := range tokens {
+		for address := range token.holders {
+			held[address] = append(held[address], token.label)
+		}
+	}
+	for address, labels := range held {
+		if len(labels) < 2 {
+			delete(held, address)
+		}
+	}
+	return held
+}
+
+// sharedHoldersPortfolioValues returns portfolio values of the addresses across all configured chains.
+func sharedHoldersPortfolioValues(ctx context.Context, apiClient apiclient.APIClienter, shared map[string][]string) map[string]decimal.Decimal {
+	addresses := make([]string, 0, len(shared))
+	for address := range shared {
+		addresses = append(addresses, address)
+	}
+
+	lock := &sync.Mutex{}
+	values := make(map[string]decimal.Decimal, len(addresses))
+	fmt.Printf("Retrieving portfolio values of shared holders...\n")
+	runWorkers(ctx, concurrency, addresses, func(address string) {
+		value := decimal.NewFromInt(0)
+		for _, chain := range cfg.Chains {
+			balances, err := apiClient.GetAddressBalances(ctx, apiclient.GetAddressBalancesReq{
+				Chain:   apiclient.Chain(chain),
+				Address: address,
+			})
+			if err != nil {
+				fmt.Printf("error retrieving balances for chain %v, address: %v; %v\n", chain, address, err)
+				continue
+			}
+			for _, balance := range balances {
+				value = value.Add(decimal.NewFromFloat(balance.Quote))
+			}
+		}
+		if ctx.Err() != nil {
+			// portfolio value is incomplete
+			return
+		}
+		lock.Lock()
+		values[address] = value
+		lock.Unlock()
+	})
+	return values
+}
+
+// overlapOf returns the overlap of holders of all given tokens.
+func overlapOf(tokens []overlapToken, isWhale func(address string) bool, whalesCounted bool) overlapRow {
+	union := make(map[string]int)
+	labels := make([]string, 0, len(tokens))
+	holdersPerToken := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		labels = append(labels, token.label)
+		holdersPerToken = append(holdersPerToken, fmt.Sprint(len(token.holders)))
+		for address := range token.holders {
+			union[address]++
+		}
+	}
+
+	row := overlapRow{
+		Tokens:          strings.Join(labels, " + "),
+		TokensCount:     int64(len(tokens)),
+		UnionHolders:    int64(len(union)),
+		WhalesCounted:   whalesCounted,
+		HoldersPerToken: strings.Join(holdersPerToken, " / "),
+	}
+	for address, count := range union {
+		if count != len(tokens) {
+			continue
+		}
+		row.SharedHolders++
+		if isWhale(address) {
+			row.SharedWhales++
+		}
+	}
+	if row.UnionHolders > 0 {
+		row.Jaccard = float64(row.SharedHolders) / float64(row.UnionHolders)
+	}
+	return row
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"aper/config"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const manifestFileName = "run.json"
+
+// runManifest records parameters a run was started with and the result files it produced.
+type runManifest struct {
+	Command     string            `json:"command"`
+	RunID       string            `json:"run_id"`
+	Flags       map[string]string `json:"flags"`
+	Chains      []string          `json:"chains"`
+	TokenSymbol string            `json:"token_symbol,omitempty"`
+	Blocks      map[string]int    `json:"blocks,omitempty"` // chain to block height results are as of
+	Rules       *config.Rules     `json:"rules,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+	Interrupted bool              `json:"interrupted"`
+	Files       []string          `json:"files"` // relative to the run directory
+
+	dir string
+}
+
+// newRunManifest returns the manifest of a new run or, when resuming, the one saved in the run directory.
+func newRunManifest(cmd *cobra.Command, runID, runDir string) *runManifest {
+	m, err := readRunManifest(runDir)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		m = &runManifest{
+			dir:       runDir,
+			StartedAt: time.Now(),
+		}
+	default:
+		log.Fatalf("error reading run manifest in %v: %v", runDir, err)
+	}
+
+	m.Command = cmd.Name()
+	m.RunID = runID
+	m.Chains = cfg.Chains
+	m.Flags = make(map[string]string)
+	visit := func(flag *pflag.Flag) {
+		m.Flags[flag.Name] = flag.Value.String()
+	}
+	cmd.InheritedFlags().VisitAll(visit)
+	cmd.Flags().VisitAll(visit)
+	return m
+}
+
+// readRunManifest returns the manifest saved in the run directory.
+func readRunManifest(runDir string) (*runManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(runDir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &runManifest{
+		dir: runDir,
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "failure parsing run manifest")
+	}
+	return m, nil
+}
+
+// resumedFlags are flags changing which holdings are journaled, a run must be resumed with the same values.
+var resumedFlags = []string{"tokenAddress", "tokenChain", "date", "minHoldingUSDValue", "minTokenQnt", "maxHolders"}
+
+// checkResumedRun returns an error when the run was started with parameters other than the current ones,
+// as holdings journaled in the run would then be merged into a different analysis.
+func (m *runManifest) checkResumedRun(current *runManifest) error {
+	var mismatches []string
+	for _, name := range resumedFlags {
+		saved, ok := m.Flags[name]
+		if !ok {
+			continue
+		}
+		if !sameFlagValue(name, saved, current.Flags[name]) {
+			mismatches = append(mismatches, fmt.Sprintf("%s %q instead of %q", name, current.Flags[name], saved))
+		}
+	}
+	if m.Chains != nil && !sameChains(m.Chains, current.Chains) {
+		mismatches = append(mismatches, fmt.Sprintf("chains %v instead of %v", current.Chains, m.Chains))
+	}
+	if m.Rules != nil {
+		saved, err := json.Marshal(m.Rules)
+		if err != nil {
+			return err
+		}
+		rules, err := json.Marshal(current.Rules)
+		if err != nil {
+			return err
+		}
+		if string(saved) != string(rules) {
+			mismatches = append(mismatches, "different screening rules")
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.Errorf("run %s was started with other parameters: %s", m.RunID, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// sameChains reports whether both lists hold the same chains, in any order.
+func sameChains(saved, current []string) bool {
+	if len(saved) != len(current) {
+		return false
+	}
+	sorted := func(chains []string) []string {
+		chains = append([]string{}, chains...)
+		sort.Strings(chains)
+		return chains
+	}
+	saved, current = sorted(saved), sorted(current)
+	for i := range saved {
+		if saved[i] != current[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFlagValue(name, saved, current string) bool {
+	switch name {
+	case "tokenAddress":
+		return strings.EqualFold(saved, current)
+	case "minHoldingUSDValue":
+		savedValue, err := decimal.NewFromString(saved)
+		if err != nil {
+			return saved == current
+		}
+		currentValue, err := decimal.NewFromString(current)
+		return err == nil && savedValue.Equal(currentValue)
+	}
+	return saved == current
+}
+
+func (m *runManifest) addFile(path string) {
+	if path == "" {
+		return
+	}
+	if rel, err := filepath.Rel(m.dir, path); err == nil {
+		path = rel
+	}
+	for _, f := range m.Files {
+		if f == path {
+			return
+		}
+	}
+	m.Files = append(m.Files, path)
+}
+
+func (m *runManifest) finish(interrupted bool) {
+	now := time.Now()
+	m.FinishedAt = &now
+	m.Interrupted = interrupted
+	m.save()
+}
+
+func (m *runManifest) save() {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Fatalf("error marshalling run manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.dir, manifestFileName), data, 0644); err != nil {
+		log.Fatalf("error saving run manifest in %v: %v", m.dir, err)
+	}
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// createRunDir creates the directory of a new run under the results directory and returns the run ID
+// and the directory. A suffix is added to the run ID when another run got the same ID, e.g. when both
+// were started in the same second.
+func createRunDir(runID string) (string, string) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatalf("error creating results directory %v: %v", outDir, err)
+	}
+	id := runID
+	for i := 2; ; i++ {
+		dir := filepath.Join(outDir, id)
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			fmt.Printf("Saving results in %s\n", dir)
+			return id, dir
+		}
+		if !os.IsExist(err) {
+			log.Fatalf("error creating results directory %v: %v", dir, err)
+		}
+		id = fmt.Sprintf("%s-%d", runID, i)
+	}
+}
+
+// newRunID joins the parts into a run ID safe to use as a directory name.
+func newRunID(parts ...interface{}) string {
+	id := ""
+	for i, part := range parts {
+		if i > 0 {
+			id += "_"
+		}
+		id += unsafeFileNameChars.ReplaceAllString(fmt.Sprint(part), "-")
+	}
+	return id + "_" + time.Now().Format("20060102-150405")
+}
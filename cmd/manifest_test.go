@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"aper/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckResumedRun(t *testing.T) {
+	maxMarketCap := 50000000.0
+	rules := &config.Rules{MarketCap: config.Range{Max: &maxMarketCap}}
+	saved := &runManifest{
+		RunID: "APE_ETHEREUM_latest_20230601-120000",
+		Flags: map[string]string{
+			"tokenAddress":       "0xabcdef1111111111111111111111111111111111",
+			"tokenChain":         "ETHEREUM",
+			"date":               "",
+			"minHoldingUSDValue": "100",
+			"whaleThreshold":     "100000",
+			"minTokenQnt":        "100",
+			"maxHolders":         "0",
+		},
+		Chains: []string{"ETHEREUM", "ARBITRUM"},
+		Rules:  rules,
+	}
+
+	tests := []struct {
+		desc    string
+		flags   map[string]string
+		chains  []string
+		rules   *config.Rules
+		wantErr bool
+	}{
+		{desc: "same parameters", flags: map[string]string{}, rules: rules},
+		{desc: "chains in another order", flags: map[string]string{}, chains: []string{"ARBITRUM", "ETHEREUM"}, rules: rules},
+		{desc: "address in another case", flags: map[string]string{"tokenAddress": "0xABCDEF1111111111111111111111111111111111"}, rules: rules},
+		{desc: "same holding value written differently", flags: map[string]string{"minHoldingUSDValue": "100.0"}, rules: rules},
+		{desc: "other whale threshold", flags: map[string]string{"whaleThreshold": "200000"}, rules: rules},
+		{desc: "other token", flags: map[string]string{"tokenAddress": "0x2222222222222222222222222222222222222222"}, rules: rules, wantErr: true},
+		{desc: "other chain", flags: map[string]string{"tokenChain": "ARBITRUM"}, rules: rules, wantErr: true},
+		{desc: "other date", flags: map[string]string{"date": "2023-06-01"}, rules: rules, wantErr: true},
+		{desc: "other holding value", flags: map[string]string{"minHoldingUSDValue": "1000"}, rules: rules, wantErr: true},
+		{desc: "other min token quantity", flags: map[string]string{"minTokenQnt": "1000"}, rules: rules, wantErr: true},
+		{desc: "other max holders", flags: map[string]string{"maxHolders": "500"}, rules: rules, wantErr: true},
+		{desc: "other chains", flags: map[string]string{}, chains: []string{"ETHEREUM", "FANTOM"}, rules: rules, wantErr: true},
+		{desc: "fewer chains", flags: map[string]string{}, chains: []string{"ETHEREUM"}, rules: rules, wantErr: true},
+		{desc: "other rules", flags: map[string]string{}, rules: &config.Rules{}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			current := &runManifest{Flags: make(map[string]string), Chains: saved.Chains, Rules: tc.rules}
+			if tc.chains != nil {
+				current.Chains = tc.chains
+			}
+			for name, value := range saved.Flags {
+				current.Flags[name] = value
+			}
+			for name, value := range tc.flags {
+				current.Flags[name] = value
+			}
+			err := saved.checkResumedRun(current)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateRunDirAddsSuffixToTakenRunID(t *testing.T) {
+	original := outDir
+	outDir = filepath.Join(t.TempDir(), "results")
+	t.Cleanup(func() {
+		outDir = original
+	})
+
+	for _, want := range []string{"APE_ETHEREUM_latest_20230601-120000", "APE_ETHEREUM_latest_20230601-120000-2", "APE_ETHEREUM_latest_20230601-120000-3"} {
+		runID, runDir := createRunDir("APE_ETHEREUM_latest_20230601-120000")
+		if runID != want || runDir != filepath.Join(outDir, want) {
+			t.Errorf("got run %s in %s, want %s", runID, runDir, want)
+		}
+	}
+}
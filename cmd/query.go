@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"aper/output"
+	"aper/store"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	query.PersistentFlags().StringVar(&dbPath, "db", "", "SQLite database runs were recorded in with balancesOfTokensHolders --db")
+	_ = query.MarkPersistentFlagRequired("db")
+
+	for _, c := range []*cobra.Command{queryCommonTokens, queryCommonWhales} {
+		c.Flags().StringVar(&querySince, "since", "", "only runs started since that date, in 2006-01-02 format")
+		c.Flags().IntVar(&queryMinTokens, "minTokens", 2, "minimal number of different analysed tokens")
+	}
+
+	query.AddCommand(queryRuns)
+	query.AddCommand(queryCommonTokens)
+	query.AddCommand(queryCommonWhales)
+}
+
+var (
+	querySince     string
+	queryMinTokens int
+)
+
+var query = &cobra.Command{
+	Use:   "query",
+	Short: "Print reports of runs recorded in the database",
+}
+
+var queryRuns = &cobra.Command{
+	Use:   "runs",
+	Short: "List recorded runs, the most recent first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db := openStore()
+		defer db.Close()
+
+		runs, err := db.Runs()
+		if err != nil {
+			log.Fatalf("error querying runs: %v", err)
+		}
+		rows := make([]runSummaryRow, 0, len(runs))
+		for _, run := range runs {
+			rows = append(rows, runSummaryRow(run))
+		}
+		printTable(rows)
+		return nil
+	},
+}
+
+var queryCommonTokens = &cobra.Command{
+	Use:   "commonTokens",
+	Short: "Found tokens held by holders of several analysed tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db := openStore()
+		defer db.Close()
+
+		tokens, err := db.CommonTokens(parseQuerySince(), queryMinTokens)
+		if err != nil {
+			log.Fatalf("error querying common tokens: %v", err)
+		}
+		rows := make([]commonTokenRow, 0, len(tokens))
+		for _, token := range tokens {
+			rows = append(rows, commonTokenRow(token))
+		}
+		printTable(rows)
+		return nil
+	},
+}
+
+var queryCommonWhales = &cobra.Command{
+	Use:   "commonWhales",
+	Short: "Whales found among holders of several analysed tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db := openStore()
+		defer db.Close()
+
+		whales, err := db.CommonWhales(parseQuerySince(), queryMinTokens)
+		if err != nil {
+			log.Fatalf("error querying common whales: %v", err)
+		}
+		rows := make([]commonWhaleRow, 0, len(whales))
+		for _, w := range whales {
+			rows = append(rows, commonWhaleRow(w))
+		}
+		printTable(rows)
+		return nil
+	},
+}
+
+func openStore() *store.Store {
+	db, err := store.Open(dbPath)
+	if err != nil {
+		log.Fatalf("error opening database %v: %v", dbPath, err)
+	}
+	return db
+}
+
+func parseQuerySince() time.Time {
+	if querySince == "" {
+		return time.Time{}
+	}
+	since, err := time.Parse(dateFormat, querySince)
+	if err != nil {
+		log.Fatalf("error parsing date %v: %v", querySince, err)
+	}
+	return since
+}
+
+// printTable prints rows as aligned columns.
+func printTable[T output.Row](rows []T) {
+	if len(rows) == 0 {
+		fmt.Println("No results")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(rows[0].CSVHeader(), "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row.CSVRecord(), "\t"))
+	}
+	_ = w.Flush()
+}
+
+type runSummaryRow store.RunSummary
+
+func (r runSummaryRow) CSVHeader() []string {
+	return []string{"run ID", "token", "chain", "started", "finished", "holders", "analysed holders", "found tokens"}
+}
+
+func (r runSummaryRow) CSVRecord() []string {
+	finished := ""
+	switch {
+	case r.Interrupted:
+		finished = "interrupted"
+	case r.FinishedAt != nil:
+		finished = r.FinishedAt.Local().Format("2006-01-02 15:04")
+	}
+	return []string{r.ID, r.TokenSymbol, r.TokenChain, r.StartedAt.Local().Format("2006-01-02 15:04"), finished,
+		fmt.Sprint(r.Holders), fmt.Sprint(r.AnalysedHolders), fmt.Sprint(r.FoundTokens)}
+}
+
+type commonTokenRow store.CommonToken
+
+func (r commonTokenRow) CSVHeader() []string {
+	return []string{"symbol", "address", "chain", "analysed tokens", "held by holders of", "runs", "max holders", "max value"}
+}
+
+func (r commonTokenRow) CSVRecord() []string {
+	return []string{r.Symbol, r.Address, r.Chain, fmt.Sprint(r.AnalysedTokens), r.Symbols, fmt.Sprint(r.Runs),
+		fmt.Sprint(r.MaxHolders), formatFloat(r.MaxValue)}
+}
+
+type commonWhaleRow store.CommonWhale
+
+func (r commonWhaleRow) CSVHeader() []string {
+	return []string{"address", "analysed tokens", "holder of", "runs", "max portfolio value"}
+}
+
+func (r commonWhaleRow) CSVRecord() []string {
+	return []string{r.Address, fmt.Sprint(r.AnalysedTokens), r.Symbols, fmt.Sprint(r.Runs), formatFloat(r.MaxPortfolioValue)}
+}
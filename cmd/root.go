@@ -1,10 +1,36 @@
 package cmd
 
 import (
+	apiclient "aper/api-client"
+	"aper/output"
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	configEnv         = "APER_CONFIG"
+	defaultConfigPath = "./config/config.yaml"
+)
+
+var (
+	configFile      string
+	noCache         bool
+	cacheTTL        time.Duration
+	concurrency     int
+	outputFormatStr string
+	outDir          string
+	outputFormat    output.Format
+	// rateLimiters are shared by all API clients, so that the rates adapt to each API as a whole
+	rateLimiters *apiclient.Limiters
 )
 
 var rootCmd = &cobra.Command{
@@ -12,18 +38,119 @@ var rootCmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
-func Execute() {
-	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"config file (default: $APER_CONFIG, $XDG_CONFIG_HOME/aper/config.yaml, ./config/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "do not read nor write cached API responses")
+	rootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", 24*time.Hour, "how long cached API responses stay valid")
+	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 10, "number of addresses processed at once")
+	rootCmd.PersistentFlags().StringVar(&outDir, "out-dir", "./results", "directory where every run creates its own results directory")
+	rootCmd.PersistentFlags().StringVar(&outputFormatStr, "output-format", string(output.CSV), "results file format: csv, json, jsonl or parquet")
 
 	rootCmd.AddCommand(balancesOfTokensHolders)
+	rootCmd.AddCommand(whalesWatching)
+	rootCmd.AddCommand(holderOverlap)
+	rootCmd.AddCommand(serve)
+	rootCmd.AddCommand(query)
+	rootCmd.AddCommand(diffRuns)
+}
+
+func Execute() {
+	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
 
-	// TODO
-	// whales watching:
-	// - read addresses from the CSV file - input: CSV file
-	// - for each address: get transactions since date D - input: lookup date
-	// - list sold and bought tokens per address, if sold: percentage of holding of these tokens that were sold
+	// the first interrupt cancels the context so that commands can save partial results,
+	// the next one terminates the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		os.Exit(1)
 	}
 }
+
+// configFilePath returns the first config file found in order:
+// --config flag, $APER_CONFIG, XDG config dir, ./config/config.yaml.
+func configFilePath() string {
+	if configFile != "" {
+		return configFile
+	}
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	if xdgConfigHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			xdgConfigHome = filepath.Join(home, ".config")
+		}
+	}
+	if xdgConfigHome != "" {
+		path := filepath.Join(xdgConfigHome, "aper", "config.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return defaultConfigPath
+}
+
+// initConfig reads the config file into cfg. API keys can be overridden
+// with APER_API_KEY and APER_MORALIS_API_KEY environment variables.
+func initConfig() {
+	viper.SetConfigFile(configFilePath())
+
+	if err := viper.ReadInConfig(); err == nil {
+		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	} else {
+		log.Fatalf("error using config file %v: %v", viper.ConfigFileUsed(), err)
+	}
+
+	_ = viper.BindEnv("apiKey", "APER_API_KEY")
+	_ = viper.BindEnv("moralisApiKey", "APER_MORALIS_API_KEY")
+
+	if err := viper.GetViper().Unmarshal(&cfg); err != nil {
+		log.Fatalf("error unmarshalling config: %v", err)
+	}
+
+	rateLimiters = apiclient.NewLimiters(cfg.RateLimits)
+}
+
+func initOutputFormat() {
+	var err error
+	outputFormat, err = output.ParseFormat(outputFormatStr)
+	if err != nil {
+		log.Fatalf("error parsing output format: %v", err)
+	}
+}
+
+// initCache returns the on-disk API responses cache or nil when caching is disabled.
+func initCache() *apiclient.Cache {
+	if noCache {
+		return nil
+	}
+
+	dir := filepath.Join(".", "cache")
+	if userCacheDir, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(userCacheDir, "aper")
+	}
+
+	cache, err := apiclient.NewCache(dir, cacheTTL)
+	if err != nil {
+		log.Fatalf("error initializing cache in %v: %v", dir, err)
+	}
+	return cache
+}
+
+// newAPIClient is a variable so that tests can replace the API client with a fake one.
+var newAPIClient = func(cache *apiclient.Cache) apiclient.APIClienter {
+	client := apiclient.NewAPIClient(&cfg, rateLimiters)
+	if cache == nil {
+		return client
+	}
+	return apiclient.NewCachedAPIClient(client, cache)
+}
@@ -0,0 +1,476 @@
+package cmd
+
+import (
+	"aper/analysis"
+	apiclient "aper/api-client"
+	"aper/config"
+	"aper/screening"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	serve.PersistentFlags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "address the HTTP server listens on, jobs are accepted without authentication")
+	serve.PersistentFlags().IntVar(&maxJobs, "maxJobs", 1, "number of analysis jobs running at once, other jobs are queued")
+	serve.PersistentFlags().IntVar(&maxFinishedJobs, "maxFinishedJobs", 100, "number of finished jobs kept with their results, the oldest are forgotten")
+	serve.PersistentFlags().StringVar(&rulesPath, "rules", "", "YAML file with token screening rules, overrides rules from config")
+}
+
+var (
+	serveAddr       string
+	maxJobs         int
+	maxFinishedJobs int
+)
+
+type jobStatus string
+
+const (
+	jobQueued      jobStatus = "queued"
+	jobRunning     jobStatus = "running"
+	jobDone        jobStatus = "done"
+	jobInterrupted jobStatus = "interrupted" // the server was stopped, results are partial
+	jobFailed      jobStatus = "failed"
+)
+
+// jobRequest is the body of a job submission, thresholds left out default to the flags defaults
+// of balancesOfTokensHolders.
+type jobRequest struct {
+	TokenAddress       string           `json:"token_address"`
+	TokenChain         string           `json:"token_chain"`
+	MinTokenQnt        *int             `json:"min_token_qnt,omitempty"`
+	MinHoldingUSDValue *decimal.Decimal `json:"min_holding_usd_value,omitempty"`
+	WhaleThreshold     *decimal.Decimal `json:"whale_threshold"`
+	Date               string           `json:"date,omitempty"` // 2006-01-02, latest blocks when empty
+	MaxHolders         int              `json:"max_holders,omitempty"`
+}
+
+// options validates the request and returns analysis options with defaults applied.
+func (r jobRequest) options(rules config.Rules) (analysis.Options, error) {
+	opts := analysis.Options{
+		TokenAddress:       r.TokenAddress,
+		TokenChain:         apiclient.Chain(r.TokenChain),
+		Chains:             cfg.Chains,
+		MinTokenQnt:        100,
+		MinHoldingUSDValue: decimal.NewFromInt(100),
+		MaxHolders:         r.MaxHolders,
+		Concurrency:        concurrency,
+		Rules:              rules,
+	}
+	if r.TokenAddress == "" {
+		return opts, errors.New("token_address is required")
+	}
+	if _, ok := apiclient.Chains[opts.TokenChain]; !ok {
+		return opts, errors.Errorf("unsupported token_chain %q", r.TokenChain)
+	}
+	if r.WhaleThreshold == nil {
+		return opts, errors.New("whale_threshold is required")
+	}
+	opts.WhaleThreshold = *r.WhaleThreshold
+	if r.MinTokenQnt != nil {
+		opts.MinTokenQnt = *r.MinTokenQnt
+	}
+	if r.MinHoldingUSDValue != nil {
+		opts.MinHoldingUSDValue = *r.MinHoldingUSDValue
+	}
+	if r.Date != "" {
+		date, err := time.Parse(dateFormat, r.Date)
+		if err != nil {
+			return opts, errors.Errorf("date %q is not in 2006-01-02 format", r.Date)
+		}
+		opts.Date = date
+	}
+	return opts, nil
+}
+
+type job struct {
+	lock        *sync.RWMutex
+	id          string
+	request     jobRequest
+	status      jobStatus
+	progress    analysis.Progress
+	err         error
+	result      *analysis.Result
+	submittedAt time.Time
+	startedAt   *time.Time
+	finishedAt  *time.Time
+}
+
+// jobView is the JSON representation of a job's status and progress.
+type jobView struct {
+	ID              string         `json:"id"`
+	Status          jobStatus      `json:"status"`
+	Stage           analysis.Stage `json:"stage,omitempty"`
+	Processed       int            `json:"processed"` // holders, while balances are retrieved
+	Total           int            `json:"total"`
+	Error           string         `json:"error,omitempty"`
+	Request         jobRequest     `json:"request"`
+	TokenSymbol     string         `json:"token_symbol,omitempty"`
+	AnalysedHolders int            `json:"analysed_holders"`
+	SubmittedAt     time.Time      `json:"submitted_at"`
+	StartedAt       *time.Time     `json:"started_at,omitempty"`
+	FinishedAt      *time.Time     `json:"finished_at,omitempty"`
+}
+
+func (j *job) view() jobView {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	v := jobView{
+		ID:          j.id,
+		Status:      j.status,
+		Stage:       j.progress.Stage,
+		Processed:   j.progress.Processed,
+		Total:       j.progress.Total,
+		Request:     j.request,
+		SubmittedAt: j.submittedAt,
+		StartedAt:   j.startedAt,
+		FinishedAt:  j.finishedAt,
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+	if j.result != nil {
+		v.TokenSymbol = j.result.TokenSymbol
+		v.AnalysedHolders = j.result.AnalysedHolders
+	}
+	return v
+}
+
+func (j *job) setProgress(p analysis.Progress) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.progress = p
+}
+
+func (j *job) start() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	now := time.Now()
+	j.status = jobRunning
+	j.startedAt = &now
+}
+
+func (j *job) finish(result *analysis.Result, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	now := time.Now()
+	j.finishedAt = &now
+	j.result = result
+	j.err = err
+	switch {
+	case err != nil:
+		j.status = jobFailed
+	case result.Interrupted:
+		j.status = jobInterrupted
+	default:
+		j.status = jobDone
+	}
+}
+
+// finishedResult returns the job's result once the job is done or interrupted.
+func (j *job) finishedResult() (*analysis.Result, bool) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.result, j.result != nil
+}
+
+// jobServer runs analysis jobs submitted over HTTP. All jobs share the API client and the token metadata
+// provider, so that the rate limits and the cache apply to the server as a whole.
+type jobServer struct {
+	ctx   context.Context // jobs are interrupted once it is done
+	deps  analysis.Dependencies
+	rules config.Rules
+	slots chan struct{} // limits the number of running jobs
+
+	lock        *sync.RWMutex
+	jobs        map[string]*job
+	maxFinished int // finished jobs kept in memory with their results
+}
+
+func newJobServer(ctx context.Context, deps analysis.Dependencies, rules config.Rules, maxJobs, maxFinished int) *jobServer {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	if maxFinished < 0 {
+		maxFinished = 0
+	}
+	return &jobServer{
+		ctx:         ctx,
+		deps:        deps,
+		rules:       rules,
+		slots:       make(chan struct{}, maxJobs),
+		lock:        &sync.RWMutex{},
+		jobs:        make(map[string]*job),
+		maxFinished: maxFinished,
+	}
+}
+
+// handler serves:
+//
+//	POST /jobs                 submit a job, the body is a jobRequest
+//	GET  /jobs                 list jobs
+//	GET  /jobs/{id}            job status and progress
+//	GET  /jobs/{id}/tokens     found tokens per chain, ?chain= and ?sort-by= as in balancesOfTokensHolders
+//	GET  /jobs/{id}/skipped    skipped tokens per chain with the rule that excluded them
+//	GET  /jobs/{id}/whales     whales with their holdings
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.submit(w, r)
+		case http.MethodGet:
+			s.list(w)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		id, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+		s.lock.RLock()
+		j, ok := s.jobs[id]
+		s.lock.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.Errorf("job %q not found", id))
+			return
+		}
+		if resource == "" {
+			writeJSON(w, http.StatusOK, j.view())
+			return
+		}
+		s.results(w, r, j, resource)
+	})
+	return mux
+}
+
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	var request jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrapf(err, "failure parsing job request"))
+		return
+	}
+	opts, err := request.options(s.rules)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	j := &job{
+		lock:        &sync.RWMutex{},
+		id:          newJobID(),
+		request:     request,
+		status:      jobQueued,
+		submittedAt: time.Now(),
+	}
+	deps := s.deps
+	deps.Progress = j.setProgress
+	if serverLog := s.deps.Log; serverLog != nil {
+		// jobs run concurrently, prefix their lines with the job ID
+		deps.Log = func(format string, args ...interface{}) {
+			serverLog("Job %s: %s", j.id, fmt.Sprintf(format, args...))
+		}
+	}
+	analyzer, err := analysis.NewAnalyzer(opts, deps)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.lock.Lock()
+	s.jobs[j.id] = j
+	s.lock.Unlock()
+	fmt.Printf("Job %s submitted for %s on %s\n", j.id, request.TokenAddress, request.TokenChain)
+
+	go s.run(j, analyzer)
+	writeJSON(w, http.StatusAccepted, j.view())
+}
+
+func (s *jobServer) run(j *job, analyzer *analysis.Analyzer) {
+	defer s.prune()
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.ctx.Done():
+		j.finish(nil, errors.New("server stopped before the job started"))
+		return
+	}
+	defer func() { <-s.slots }()
+
+	j.start()
+	result, err := analyzer.Run(s.ctx)
+	j.finish(result, err)
+	fmt.Printf("Job %s finished: %s\n", j.id, j.view().Status)
+}
+
+// prune forgets the jobs finished the longest ago beyond maxFinished, so that their results are freed.
+// Queued and running jobs are kept.
+func (s *jobServer) prune() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	type finishedJob struct {
+		id         string
+		finishedAt time.Time
+	}
+	var finished []finishedJob
+	for id, j := range s.jobs {
+		if v := j.view(); v.FinishedAt != nil {
+			finished = append(finished, finishedJob{id: id, finishedAt: *v.FinishedAt})
+		}
+	}
+	if len(finished) <= s.maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].finishedAt.Before(finished[j].finishedAt)
+	})
+	for _, j := range finished[:len(finished)-s.maxFinished] {
+		delete(s.jobs, j.id)
+	}
+}
+
+func (s *jobServer) list(w http.ResponseWriter) {
+	s.lock.RLock()
+	views := make([]jobView, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		views = append(views, j.view())
+	}
+	s.lock.RUnlock()
+
+	sort.SliceStable(views, func(i, j int) bool {
+		return views[i].SubmittedAt.Before(views[j].SubmittedAt)
+	})
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *jobServer) results(w http.ResponseWriter, r *http.Request, j *job, resource string) {
+	result, ok := j.finishedResult()
+	if !ok {
+		writeError(w, http.StatusConflict, errors.Errorf("job %s is %s, results are available once it is done", j.id, j.view().Status))
+		return
+	}
+
+	chains := cfg.Chains
+	if chain := r.URL.Query().Get("chain"); chain != "" {
+		chains = []string{chain}
+	}
+
+	switch resource {
+	case "tokens":
+		sortTokens, err := parseSortBy(valueOr(r.URL.Query().Get("sort-by"), "value"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		rows := make(map[string][]tokenRow, len(chains))
+		for _, chain := range chains {
+			rows[chain] = make([]tokenRow, 0, len(result.Tokens[chain]))
+			for _, token := range result.Tokens[chain] {
+				rows[chain] = append(rows[chain], newTokenRow(token))
+			}
+			sortTokens(rows[chain])
+		}
+		writeJSON(w, http.StatusOK, rows)
+	case "skipped":
+		rows := make(map[string][]skippedTokenRow, len(chains))
+		for _, chain := range chains {
+			rows[chain] = make([]skippedTokenRow, 0, len(result.SkippedTokens[chain]))
+			for _, token := range result.SkippedTokens[chain] {
+				rows[chain] = append(rows[chain], newSkippedTokenRow(token))
+			}
+		}
+		writeJSON(w, http.StatusOK, rows)
+	case "whales":
+		rows := make([]whaleRow, 0, len(result.Whales))
+		for _, w := range result.Whales {
+			rows = append(rows, newWhaleRow(w))
+		}
+		writeJSON(w, http.StatusOK, rows)
+	default:
+		writeError(w, http.StatusNotFound, errors.Errorf("unknown resource %q, expected tokens, skipped or whales", resource))
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("error generating job ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("error writing response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+var serve = &cobra.Command{
+	Use:   "serve",
+	Short: "Run holder analyses submitted over HTTP and serve their results as JSON",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		initConfig()
+		ctx := cmd.Context()
+
+		cache := initCache()
+		metadataProvider, _ := newMetadataProvider(cache)
+		deps := analysis.Dependencies{
+			APIClient: newAPIClient(cache),
+			Metadata:  metadataProvider,
+			Log:       logf,
+		}
+		rules := loadRules()
+		if _, err := screening.NewEngine(rules); err != nil {
+			log.Fatalf("error initializing screening rules: %v", err)
+		}
+		jobs := newJobServer(ctx, deps, rules, maxJobs, maxFinishedJobs)
+
+		server := &http.Server{
+			Addr:    serveAddr,
+			Handler: jobs.handler(),
+		}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				fmt.Printf("error stopping server: %s\n", err)
+			}
+		}()
+
+		fmt.Printf("Listening on %s\n", serveAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("error serving on %v: %v", serveAddr, err)
+		}
+		return nil
+	},
+}
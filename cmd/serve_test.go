@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"aper/analysis"
+	apiclient "aper/api-client"
+	"aper/screening"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jobsLog keeps lines logged by jobs.
+type jobsLog struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (l *jobsLog) logf(format string, args ...interface{}) {
+	l.lock.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.lock.Unlock()
+}
+
+func newTestJobServer(t *testing.T) (*httptest.Server, *jobsLog) {
+	t.Helper()
+	fake, err := apiclient.NewFakeAPIClientFromFile(filepath.Join("testdata", "covalent.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coingecko := newCoingeckoStandIn(t)
+
+	originalChains := cfg.Chains
+	cfg.Chains = []string{"ETHEREUM", "ARBITRUM"}
+	t.Cleanup(func() {
+		cfg.Chains = originalChains
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	log := &jobsLog{}
+	jobs := newJobServer(ctx, analysis.Dependencies{
+		APIClient: fake,
+		Metadata:  apiclient.NewCoingeckoProvider(coingecko.URL, apiclient.NewAdaptiveLimiter("coingecko", 1000, 5)),
+		Log:       log.logf,
+	}, screening.DefaultRules, 1, 10)
+
+	server := httptest.NewServer(jobs.handler())
+	t.Cleanup(server.Close)
+	return server, log
+}
+
+func doJSON(t *testing.T, method, url string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServeJob(t *testing.T) {
+	server, log := newTestJobServer(t)
+
+	var submitted jobView
+	status := doJSON(t, http.MethodPost, server.URL+"/jobs", map[string]interface{}{
+		"token_address":   "0x1111111111111111111111111111111111111111",
+		"token_chain":     "ETHEREUM",
+		"whale_threshold": "100000",
+	}, &submitted)
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+	}
+
+	var job jobView
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		doJSON(t, http.MethodGet, server.URL+"/jobs/"+submitted.ID, nil, &job)
+		if job.Status != jobQueued && job.Status != jobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != jobDone {
+		t.Fatalf("job %s: %s", job.Status, job.Error)
+	}
+	if job.TokenSymbol != "APE" || job.AnalysedHolders != 3 {
+		t.Errorf("got symbol %s and %d analysed holders, want APE and 3", job.TokenSymbol, job.AnalysedHolders)
+	}
+
+	var tokens map[string][]tokenRow
+	if status := doJSON(t, http.MethodGet, server.URL+"/jobs/"+submitted.ID+"/tokens?chain=ETHEREUM", nil, &tokens); status != http.StatusOK {
+		t.Fatalf("got status %d fetching tokens", status)
+	}
+	if len(tokens["ETHEREUM"]) != 1 || tokens["ETHEREUM"][0].Symbol != "GOOD" || tokens["ETHEREUM"][0].Holders != 3 {
+		t.Errorf("got tokens %+v, want GOOD held by 3 holders", tokens)
+	}
+
+	var whales []whaleRow
+	if status := doJSON(t, http.MethodGet, server.URL+"/jobs/"+submitted.ID+"/whales", nil, &whales); status != http.StatusOK {
+		t.Fatalf("got status %d fetching whales", status)
+	}
+	if len(whales) != 1 || whales[0].Address != "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" {
+		t.Errorf("got whales %+v, want 0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", whales)
+	}
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if len(log.lines) == 0 {
+		t.Error("got no lines logged by the job")
+	}
+	for _, line := range log.lines {
+		if !strings.HasPrefix(line, "Job "+submitted.ID+": ") {
+			t.Errorf("got line %q, want it prefixed with the job ID", line)
+		}
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	server, _ := newTestJobServer(t)
+
+	tests := []struct {
+		desc   string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{
+			desc:   "missing whale threshold",
+			method: http.MethodPost,
+			path:   "/jobs",
+			body:   map[string]interface{}{"token_address": "0x1111111111111111111111111111111111111111", "token_chain": "ETHEREUM"},
+			want:   http.StatusBadRequest,
+		},
+		{
+			desc:   "unsupported chain",
+			method: http.MethodPost,
+			path:   "/jobs",
+			body:   map[string]interface{}{"token_address": "0x1", "token_chain": "BITCOIN", "whale_threshold": "100000"},
+			want:   http.StatusBadRequest,
+		},
+		{
+			desc:   "incorrect date",
+			method: http.MethodPost,
+			path:   "/jobs",
+			body:   map[string]interface{}{"token_address": "0x1", "token_chain": "ETHEREUM", "whale_threshold": "100000", "date": "23-06-2023"},
+			want:   http.StatusBadRequest,
+		},
+		{
+			desc:   "unknown job",
+			method: http.MethodGet,
+			path:   "/jobs/unknown",
+			want:   http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var resp map[string]interface{}
+			if got := doJSON(t, tc.method, server.URL+tc.path, tc.body, &resp); got != tc.want {
+				t.Errorf("got status %d, want %d: %v", got, tc.want, resp)
+			}
+		})
+	}
+}
+
+func TestJobServerPrunesFinishedJobs(t *testing.T) {
+	s := newJobServer(context.Background(), analysis.Dependencies{}, screening.DefaultRules, 1, 2)
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "running", "d"} {
+		j := &job{lock: &sync.RWMutex{}, id: id, status: jobRunning}
+		if id != "running" {
+			finishedAt := start.Add(time.Duration(i) * time.Minute)
+			j.status, j.finishedAt = jobDone, &finishedAt
+		}
+		s.jobs[id] = j
+	}
+
+	s.prune()
+
+	for id, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true, "running": true} {
+		if _, ok := s.jobs[id]; ok != want {
+			t.Errorf("job %s kept: got %v, want %v", id, ok, want)
+		}
+	}
+}
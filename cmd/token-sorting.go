@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tokenSortKeys are values found tokens can be sorted by with --sort-by.
+var tokenSortKeys = map[string]func(r tokenRow) float64{
+	"value":   func(r tokenRow) float64 { return r.Value },
+	"holders": func(r tokenRow) float64 { return float64(r.Holders) },
+	"share":   func(r tokenRow) float64 { return r.HoldersShare },
+	"median":  func(r tokenRow) float64 { return r.MedianPosition },
+	"mean":    func(r tokenRow) float64 { return r.MeanPosition },
+	"gini":    func(r tokenRow) float64 { return r.Gini },
+	"hhi":     func(r tokenRow) float64 { return r.HHI },
+}
+
+// parseSortBy parses "key" or "key:asc|desc", descending being the default order.
+func parseSortBy(s string) (func(rows []tokenRow), error) {
+	key, order, _ := strings.Cut(s, ":")
+	value, ok := tokenSortKeys[key]
+	if !ok {
+		keys := make([]string, 0, len(tokenSortKeys))
+		for k := range tokenSortKeys {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("unknown sort key %q, expected one of: %s", key, strings.Join(keys, ", "))
+	}
+
+	var ascending bool
+	switch order {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		return nil, fmt.Errorf("unknown sort order %q, expected asc or desc", order)
+	}
+
+	return func(rows []tokenRow) {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if ascending {
+				return value(rows[i]) < value(rows[j])
+			}
+			return value(rows[i]) > value(rows[j])
+		})
+	}, nil
+}
@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	apiclient "aper/api-client"
+	"aper/output"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	whalesWatching.PersistentFlags().StringVar(&whalesFilePath, "whalesFile", "", "CSV, JSON or JSONL file with whales produced by balancesOfTokensHolders")
+	_ = whalesWatching.MarkPersistentFlagRequired("whalesFile")
+
+	whalesWatching.PersistentFlags().StringVar(&sinceDate, "since", "", "lookup date in 2006-01-02 format")
+	_ = whalesWatching.MarkPersistentFlagRequired("since")
+}
+
+var (
+	whalesFilePath string
+	sinceDate      string
+)
+
+type tokenFlow struct {
+	symbol   string
+	address  string
+	decimals int
+	bought   apiclient.TokenAmount
+	sold     apiclient.TokenAmount
+	balance  apiclient.TokenAmount
+}
+
+type whaleReportRow struct {
+	address string
+	chain   string
+	flow    *tokenFlow
+}
+
+type whalesReport struct {
+	lock *sync.RWMutex
+	rows []whaleReportRow
+}
+
+var whalesWatching = &cobra.Command{
+	Use:   "whalesWatching",
+	Short: "List tokens bought and sold by whales since given date",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		initConfig()
+		initOutputFormat()
+		ctx := cmd.Context()
+
+		since, err := time.Parse(dateFormat, sinceDate)
+		if err != nil {
+			log.Fatalf("error parsing date %v: %v", sinceDate, err)
+		}
+
+		addresses, err := readWhalesFile(whalesFilePath)
+		if err != nil {
+			log.Fatalf("error reading whales file %v: %v", whalesFilePath, err)
+		}
+		fmt.Printf("Found %d whales in %s\n", len(addresses), whalesFilePath)
+		if len(addresses) == 0 {
+			log.Fatal("Exiting...")
+		}
+
+		apiClient := newAPIClient(initCache())
+
+		runID, runDir := createRunDir(newRunID("whales-watching", sinceDate))
+		manifest := newRunManifest(cmd, runID, runDir)
+		manifest.save()
+
+		report := whalesReport{
+			lock: &sync.RWMutex{},
+		}
+
+		for _, chain := range cfg.Chains {
+			fmt.Printf("Processing %s chain...\n", chain)
+
+			runWorkers(ctx, concurrency, addresses, func(whaleAddress string) {
+				processWhale(ctx, apiClient, whaleAddress, chain, since, &report)
+			})
+			if ctx.Err() != nil {
+				fmt.Printf("Interrupted, saving partial results...\n")
+				break
+			}
+		}
+		manifest.addFile(saveWhalesReportInAFile(runDir, report.rows))
+		manifest.finish(ctx.Err() != nil)
+		return ctx.Err()
+	},
+}
+
+// readWhalesFile returns whales addresses from a whales file saved as CSV, JSON or JSONL.
+func readWhalesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl":
+		return readWhalesJSON(f)
+	}
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure parsing csv file")
+	}
+
+	addresses := make([]string, 0, len(records))
+	for i, record := range records {
+		if i == 0 && record[0] == "address" {
+			continue
+		}
+		addresses = append(addresses, record[0])
+	}
+	return addresses, nil
+}
+
+// readWhalesJSON reads addresses from a JSON array or from JSON lines of whales.
+func readWhalesJSON(r io.Reader) ([]string, error) {
+	whales, err := readJSONRows[whaleRow](r)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(whales))
+	for _, w := range whales {
+		addresses = append(addresses, w.Address)
+	}
+	return addresses, nil
+}
+
+// readJSONRows reads rows from a JSON array or from JSON lines.
+func readJSONRows[T any](r io.Reader) ([]T, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []T
+	if data = bytes.TrimSpace(data); bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, errors.Wrapf(err, "failure parsing json file")
+		}
+		return rows, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var row T
+		if err := dec.Decode(&row); err != nil {
+			return nil, errors.Wrapf(err, "failure parsing json lines file")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func processWhale(ctx context.Context, apiClient apiclient.APIClienter, whaleAddress, chain string, since time.Time, report *whalesReport) {
+	transactions, err := apiClient.GetAddressTransactions(ctx, apiclient.GetAddressTransactionsReq{
+		Chain:   apiclient.Chain(chain),
+		Address: whaleAddress,
+		Since:   since,
+	})
+	if err != nil {
+		fmt.Printf("error retrieving transactions for chain %v, address: %v; %v\n", chain, whaleAddress, err)
+		return
+	}
+
+	flows := tokenFlowsOfAddress(whaleAddress, transactions)
+	if len(flows) == 0 {
+		return
+	}
+
+	balances, err := apiClient.GetAddressBalances(ctx, apiclient.GetAddressBalancesReq{
+		Chain:   apiclient.Chain(chain),
+		Address: whaleAddress,
+	})
+	if err != nil {
+		fmt.Printf("error retrieving balances for chain %v, address: %v; %v\n", chain, whaleAddress, err)
+		return
+	}
+	for _, balance := range balances {
+		flow, ok := flows[strings.ToLower(balance.ContractAddress)]
+		if !ok {
+			continue
+		}
+		amount, err := apiclient.NewTokenAmount(balance.Balance, balance.ContractDecimals)
+		if err != nil {
+			continue
+		}
+		flow.balance = amount
+	}
+
+	report.lock.Lock()
+	for _, flow := range flows {
+		report.rows = append(report.rows, whaleReportRow{
+			address: whaleAddress,
+			chain:   chain,
+			flow:    flow,
+		})
+	}
+	report.lock.Unlock()
+}
+
+// tokenFlowsOfAddress sums ERC20 transfers to and from the address per token contract.
+func tokenFlowsOfAddress(address string, transactions []class_a.Transaction) map[string]*tokenFlow {
+	flows := make(map[string]*tokenFlow)
+	for _, tx := range transactions {
+		if !tx.Successful {
+			continue
+		}
+		for _, event := range tx.LogEvents {
+			from, to, rawValue, ok := transferOf(event)
+			if !ok {
+				continue
+			}
+			if !strings.EqualFold(from, address) && !strings.EqualFold(to, address) {
+				continue
+			}
+
+			contract := strings.ToLower(event.SenderAddress)
+			flow, ok := flows[contract]
+			if !ok {
+				flow = &tokenFlow{
+					address: contract,
+				}
+				if symbol, ok := event.SenderContractTickerSymbol.(string); ok {
+					flow.symbol = symbol
+				}
+				if decimals, ok := event.SenderContractDecimals.(float64); ok {
+					flow.decimals = int(decimals)
+				}
+				flow.bought = apiclient.TokenAmount{Decimals: int32(flow.decimals)}
+				flow.sold = apiclient.TokenAmount{Decimals: int32(flow.decimals)}
+				flow.balance = apiclient.TokenAmount{Decimals: int32(flow.decimals)}
+				flows[contract] = flow
+			}
+
+			value, err := apiclient.NewTokenAmount(rawValue, flow.decimals)
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(to, address) {
+				flow.bought = flow.bought.Add(value)
+			} else {
+				flow.sold = flow.sold.Add(value)
+			}
+		}
+	}
+	return flows
+}
+
+func transferOf(event class_a.LogEvent) (from, to, value string, ok bool) {
+	if event.Decoded.Name != "Transfer" {
+		return "", "", "", false
+	}
+
+	for _, param := range event.Decoded.Params {
+		v, _ := param.Value.(string)
+		switch param.Name {
+		case "from":
+			from = v
+		case "to":
+			to = v
+		case "value":
+			value = v
+		}
+	}
+
+	if from == "" || to == "" || value == "" {
+		return "", "", "", false
+	}
+	return from, to, value, true
+}
+
+// soldPercentage returns the part of the position held during the period that was sold,
+// i.e. sold / (sold + current balance).
+func (f *tokenFlow) soldPercentage() decimal.Decimal {
+	sold := f.sold.Units()
+	if sold.IsZero() {
+		return decimal.Zero
+	}
+	return sold.Div(sold.Add(f.balance.Units())).Mul(decimal.NewFromInt(100))
+}
+
+type whaleFlowRow struct {
+	Address        string  `json:"address" parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Chain          string  `json:"chain" parquet:"name=chain, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Symbol         string  `json:"symbol" parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TokenAddress   string  `json:"token_address" parquet:"name=token_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Bought         string  `json:"bought" parquet:"name=bought, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sold           string  `json:"sold" parquet:"name=sold, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SoldPercentage float64 `json:"sold_percentage" parquet:"name=sold_percentage, type=DOUBLE"`
+}
+
+func (r whaleFlowRow) CSVHeader() []string {
+	return []string{"address", "chain", "symbol", "token address", "bought", "sold", "sold %"}
+}
+
+func (r whaleFlowRow) CSVRecord() []string {
+	return []string{r.Address, r.Chain, r.Symbol, r.TokenAddress, r.Bought, r.Sold, formatFloat(r.SoldPercentage)}
+}
+
+// saveWhalesReportInAFile saves the report in the run directory and returns the file path.
+func saveWhalesReportInAFile(runDir string, rows []whaleReportRow) string {
+	if len(rows) == 0 {
+		fmt.Printf("No bought or sold tokens found\n")
+		return ""
+	}
+	fmt.Printf("Found %d bought or sold tokens. Saving results...\n", len(rows))
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].address != rows[j].address {
+			return rows[i].address < rows[j].address
+		}
+		return rows[i].chain < rows[j].chain
+	})
+
+	flowRows := make([]whaleFlowRow, 0, len(rows))
+	for _, row := range rows {
+		flowRows = append(flowRows, whaleFlowRow{
+			Address:        row.address,
+			Chain:          row.chain,
+			Symbol:         row.flow.symbol,
+			TokenAddress:   row.flow.address,
+			Bought:         row.flow.bought.String(),
+			Sold:           row.flow.sold.String(),
+			SoldPercentage: row.flow.soldPercentage().InexactFloat64(),
+		})
+	}
+
+	path, err := output.WriteFile(filepath.Join(runDir, "whales_watching"), outputFormat, flowRows)
+	if err != nil {
+		log.Fatalln("error writing whales report:", err)
+	}
+	return path
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"aper/analysis"
+	"context"
+	"fmt"
+)
+
+// progressSteps is how many times progress is printed during a run.
+const progressSteps = 20
+
+// runWorkers calls process for every address with at most concurrency calls running at once,
+// printing progress along with the current API rates.
+func runWorkers(ctx context.Context, concurrency int, addresses []string, process func(address string)) {
+	analysis.RunWorkers(ctx, concurrency, addresses, process, func(processed int) {
+		printProgress(processed, len(addresses))
+	})
+}
+
+// logf prints messages of the analysis.
+func logf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+}
+
+func printProgress(processed, total int) {
+	every := total / progressSteps
+	if every < 1 {
+		every = 1
+	}
+	if processed > 0 && processed%every == 0 {
+		fmt.Printf("Processed %d/%d addresses (%s)...\n", processed, total, rateLimiters)
+	}
+}
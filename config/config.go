@@ -4,4 +4,42 @@ type Config struct {
 	ApiKey        string   `yaml:"apiKey"`
 	MoralisApiKey string   `yaml:"moralisApiKey"`
 	Chains        []string `yaml:"chains"`
+	Rules         *Rules   `yaml:"rules"`
+	// RateLimits are the max rates, the actual rates are lowered when the APIs report rate limiting.
+	RateLimits RateLimits `yaml:"rateLimits"`
+	// MoralisURL and CoingeckoURL override the APIs base URLs, e.g. to point them to a local stand-in.
+	MoralisURL   string `yaml:"moralisUrl"`
+	CoingeckoURL string `yaml:"coingeckoUrl"`
+}
+
+// RateLimits are max requests per second sent to each API, zero means the default rate.
+type RateLimits struct {
+	Covalent  float64 `yaml:"covalent"`
+	Moralis   float64 `yaml:"moralis"`
+	Coingecko float64 `yaml:"coingecko"`
+}
+
+// Rules configure which tokens are excluded from the found tokens list.
+type Rules struct {
+	MarketCap   Range     `yaml:"marketCap"`
+	FDV         Range     `yaml:"fdv"`
+	Volume      Range     `yaml:"volume"`
+	ATHDistance Range     `yaml:"athDistance"` // percentage below ATH
+	GenesisDate DateRange `yaml:"genesisDate"`
+	Drawdown    Range     `yaml:"drawdown"`   // percentage below the highest price of the last year
+	Trend30d    Range     `yaml:"trend30d"`   // average daily price change in percentage
+	Trend90d    Range     `yaml:"trend90d"`   // average daily price change in percentage
+	DecayScore  Range     `yaml:"decayScore"` // 0 to 1, how well a falling price fits an exponential decay
+	Allow       []string  `yaml:"allow"`      // contract addresses kept regardless of other rules
+	Deny        []string  `yaml:"deny"`       // contract addresses always excluded
+}
+
+type Range struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+type DateRange struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
 }
@@ -0,0 +1,96 @@
+// Package output writes result rows to files in one of the supported formats.
+package output
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	JSON    Format = "json"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet"
+)
+
+var Formats = []Format{CSV, JSON, JSONL, Parquet}
+
+func ParseFormat(s string) (Format, error) {
+	for _, format := range Formats {
+		if strings.EqualFold(s, string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, expected one of: %s", s, formatsList())
+}
+
+// Row is a single result record. Rows are written to JSON and Parquet by their struct tags,
+// so all rows of a file must be of the same struct type, and to CSV by their CSV methods.
+type Row interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
+type Writer interface {
+	Write(row Row) error
+	// Close flushes buffered rows and closes the file.
+	Close() error
+}
+
+// Create creates the file at path, with the format extension appended, and returns a writer of rows
+// of the type schema points to.
+func Create(path string, format Format, schema interface{}) (Writer, error) {
+	f, err := os.Create(fmt.Sprintf("%s.%s", path, format))
+	if err != nil {
+		return nil, err
+	}
+
+	var w Writer
+	switch format {
+	case CSV:
+		w = newCSVWriter(f)
+	case JSON:
+		w = newJSONWriter(f)
+	case JSONL:
+		w = newJSONLWriter(f)
+	case Parquet:
+		w, err = newParquetWriter(f, schema)
+	default:
+		err = fmt.Errorf("unknown output format %q", format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteFile writes all rows to the file at path, with the format extension appended,
+// and returns the created file path.
+func WriteFile[T Row](path string, format Format, rows []T) (string, error) {
+	w, err := Create(path, format, new(T))
+	if err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			w.Close()
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", path, format), nil
+}
+
+func formatsList() string {
+	names := make([]string, 0, len(Formats))
+	for _, format := range Formats {
+		names = append(names, string(format))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,146 @@
+package output
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type csvWriter struct {
+	f             *os.File
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVWriter(f *os.File) *csvWriter {
+	return &csvWriter{
+		f: f,
+		w: csv.NewWriter(f),
+	}
+}
+
+// Write writes the header from the first row, so that headers depending on run parameters match the records.
+func (w *csvWriter) Write(row Row) error {
+	if !w.headerWritten {
+		if err := w.w.Write(row.CSVHeader()); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+	return w.w.Write(row.CSVRecord())
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// jsonWriter writes rows as a JSON array, one row per line.
+type jsonWriter struct {
+	f    *os.File
+	w    *bufio.Writer
+	rows int
+}
+
+func newJSONWriter(f *os.File) *jsonWriter {
+	return &jsonWriter{
+		f: f,
+		w: bufio.NewWriter(f),
+	}
+}
+
+func (w *jsonWriter) Write(row Row) error {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if w.rows == 0 {
+		separator = "[\n"
+	}
+	if _, err := w.w.WriteString(separator); err != nil {
+		return err
+	}
+	w.rows++
+	_, err = w.w.Write(line)
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	end := "\n]\n"
+	if w.rows == 0 {
+		end = "[]\n"
+	}
+	if _, err := w.w.WriteString(end); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+type jsonlWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(f *os.File) *jsonlWriter {
+	w := bufio.NewWriter(f)
+	return &jsonlWriter{
+		f:   f,
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (w *jsonlWriter) Write(row Row) error {
+	return w.enc.Encode(row)
+}
+
+func (w *jsonlWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// parquetWriter writes rows with the schema read from the parquet struct tags of the row type.
+type parquetWriter struct {
+	f  *os.File
+	pw *writer.ParquetWriter
+}
+
+func newParquetWriter(f *os.File, schema interface{}) (*parquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(f, schema, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetWriter{
+		f:  f,
+		pw: pw,
+	}, nil
+}
+
+func (w *parquetWriter) Write(row Row) error {
+	return w.pw.Write(row)
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.pw.WriteStop(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
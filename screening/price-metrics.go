@@ -0,0 +1,112 @@
+package screening
+
+import (
+	apiclient "aper/api-client"
+	"math"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceChartDays is the length of the price history the metrics are computed from.
+const PriceChartDays = 365
+
+// flatVariance is the variance of log prices below which a chart is considered flat.
+const flatVariance = 1e-12
+
+type PriceMetrics struct {
+	Drawdown   decimal.Decimal // percentage below the highest price of the chart
+	Trend30d   decimal.Decimal // average daily price change in percentage over the last 30 days
+	Trend90d   decimal.Decimal // average daily price change in percentage over the last 90 days
+	DecayScore decimal.Decimal // R² of an exponential fit of the whole chart when the price is falling, 0 otherwise
+}
+
+// ComputePriceMetrics returns metrics of the daily price chart or nil when the chart is too short.
+func ComputePriceMetrics(chart []apiclient.PricePoint) *PriceMetrics {
+	chart = positivePrices(chart)
+	if len(chart) < 2 {
+		return nil
+	}
+
+	last := chart[len(chart)-1]
+	ath := 0.0
+	for _, point := range chart {
+		ath = math.Max(ath, point.Price)
+	}
+
+	metrics := &PriceMetrics{
+		Drawdown: decimal.NewFromFloat((ath - last.Price) / ath * 100),
+		Trend30d: dailyChange(since(chart, last.Time.AddDate(0, 0, -30))),
+		Trend90d: dailyChange(since(chart, last.Time.AddDate(0, 0, -90))),
+	}
+
+	slope, r2 := logLinearFit(chart)
+	if slope < 0 {
+		metrics.DecayScore = decimal.NewFromFloat(r2)
+	}
+	return metrics
+}
+
+func positivePrices(chart []apiclient.PricePoint) []apiclient.PricePoint {
+	points := make([]apiclient.PricePoint, 0, len(chart))
+	for _, point := range chart {
+		if point.Price > 0 {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+func since(chart []apiclient.PricePoint, t time.Time) []apiclient.PricePoint {
+	for i, point := range chart {
+		if !point.Time.Before(t) {
+			return chart[i:]
+		}
+	}
+	return nil
+}
+
+func dailyChange(chart []apiclient.PricePoint) decimal.Decimal {
+	if len(chart) < 2 {
+		return decimal.Zero
+	}
+	slope, _ := logLinearFit(chart)
+	return decimal.NewFromFloat((math.Exp(slope) - 1) * 100)
+}
+
+// logLinearFit fits ln(price) = a + slope * days with least squares and returns the slope and R².
+func logLinearFit(chart []apiclient.PricePoint) (slope, r2 float64) {
+	n := float64(len(chart))
+	start := chart[0].Time
+
+	var sumX, sumY, sumXX, sumXY float64
+	for _, point := range chart {
+		x := point.Time.Sub(start).Hours() / 24
+		y := math.Log(point.Price)
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, 0
+	}
+	slope = (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+
+	meanY := sumY / n
+	var ssTotal, ssResidual float64
+	for _, point := range chart {
+		x := point.Time.Sub(start).Hours() / 24
+		y := math.Log(point.Price)
+		ssTotal += (y - meanY) * (y - meanY)
+		ssResidual += (y - intercept - slope*x) * (y - intercept - slope*x)
+	}
+	// rounding leaves a tiny variance in a flat chart, which would fit any tiny slope
+	if ssTotal < flatVariance {
+		return 0, 0
+	}
+	return slope, 1 - ssResidual/ssTotal
+}
@@ -0,0 +1,128 @@
+package screening
+
+import (
+	apiclient "aper/api-client"
+	"math"
+	"testing"
+	"time"
+)
+
+// dailyChart returns a chart of one point a day with prices given by price(day).
+func dailyChart(days int, price func(day int) float64) []apiclient.PricePoint {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	chart := make([]apiclient.PricePoint, 0, days)
+	for day := 0; day < days; day++ {
+		chart = append(chart, apiclient.PricePoint{Time: start.AddDate(0, 0, day), Price: price(day)})
+	}
+	return chart
+}
+
+func TestComputePriceMetrics(t *testing.T) {
+	decaying := dailyChart(PriceChartDays, func(day int) float64 { return 100 * math.Pow(0.99, float64(day)) })
+	withInvalidPrices := append([]apiclient.PricePoint{}, decaying...)
+	withInvalidPrices[10].Price = 0
+	withInvalidPrices[20].Price = -1
+
+	tests := []struct {
+		desc           string
+		chart          []apiclient.PricePoint
+		wantNil        bool
+		wantDrawdown   float64
+		wantTrend30d   float64
+		wantTrend90d   float64
+		wantDecayScore float64
+	}{
+		{
+			desc:  "flat",
+			chart: dailyChart(PriceChartDays, func(int) float64 { return 2 }),
+		},
+		{
+			desc:           "exponential decay",
+			chart:          decaying,
+			wantDrawdown:   (1 - math.Pow(0.99, PriceChartDays-1)) * 100,
+			wantTrend30d:   -1,
+			wantTrend90d:   -1,
+			wantDecayScore: 1,
+		},
+		{
+			desc:         "rising",
+			chart:        dailyChart(PriceChartDays, func(day int) float64 { return 100 * math.Pow(1.01, float64(day)) }),
+			wantTrend30d: 1,
+			wantTrend90d: 1,
+		},
+		{
+			desc:           "zero and negative prices are ignored",
+			chart:          withInvalidPrices,
+			wantDrawdown:   (1 - math.Pow(0.99, PriceChartDays-1)) * 100,
+			wantTrend30d:   -1,
+			wantTrend90d:   -1,
+			wantDecayScore: 1,
+		},
+		{
+			desc:    "single point",
+			chart:   dailyChart(1, func(int) float64 { return 2 }),
+			wantNil: true,
+		},
+		{
+			desc:    "empty",
+			wantNil: true,
+		},
+		{
+			desc:    "single positive price",
+			chart:   dailyChart(3, func(day int) float64 { return float64(day - 1) }),
+			wantNil: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			metrics := ComputePriceMetrics(tc.chart)
+			if tc.wantNil {
+				if metrics != nil {
+					t.Fatalf("expected no metrics, got %+v", metrics)
+				}
+				return
+			}
+			if metrics == nil {
+				t.Fatal("unexpected nil metrics")
+			}
+			for _, v := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"drawdown", metrics.Drawdown.InexactFloat64(), tc.wantDrawdown},
+				{"trend 30d", metrics.Trend30d.InexactFloat64(), tc.wantTrend30d},
+				{"trend 90d", metrics.Trend90d.InexactFloat64(), tc.wantTrend90d},
+				{"decay score", metrics.DecayScore.InexactFloat64(), tc.wantDecayScore},
+			} {
+				if math.Abs(v.got-v.want) > 1e-6 {
+					t.Errorf("%s: got %v, want %v", v.name, v.got, v.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogLinearFit(t *testing.T) {
+	tests := []struct {
+		desc      string
+		chart     []apiclient.PricePoint
+		wantSlope float64
+		wantR2    float64
+	}{
+		{desc: "flat", chart: dailyChart(10, func(int) float64 { return 5 })},
+		{desc: "halving every day", chart: dailyChart(10, func(day int) float64 { return math.Pow(0.5, float64(day)) }),
+			wantSlope: math.Log(0.5), wantR2: 1},
+		{desc: "single point", chart: dailyChart(1, func(int) float64 { return 5 })},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			slope, r2 := logLinearFit(tc.chart)
+			if math.Abs(slope-tc.wantSlope) > 1e-9 {
+				t.Errorf("slope: got %v, want %v", slope, tc.wantSlope)
+			}
+			if math.Abs(r2-tc.wantR2) > 1e-9 {
+				t.Errorf("r2: got %v, want %v", r2, tc.wantR2)
+			}
+		})
+	}
+}
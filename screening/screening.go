@@ -0,0 +1,218 @@
+// Package screening decides which tokens held by analysed holders are worth reporting.
+package screening
+
+import (
+	apiclient "aper/api-client"
+	"aper/config"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const dateFormat = "2006-01-02"
+
+// DefaultRules are used when no rules are configured.
+var DefaultRules = config.Rules{
+	MarketCap: config.Range{
+		Max: float64Ptr(50000000),
+	},
+	GenesisDate: config.DateRange{
+		From: "2022-04-01",
+	},
+}
+
+type Token struct {
+	Chain   apiclient.Chain
+	Address string
+	Info    *apiclient.TokenInfo
+	Metrics *PriceMetrics
+}
+
+// Rule excludes tokens not matching its predicate. Tokens missing the data the rule is based on are not excluded.
+type Rule interface {
+	Name() string
+	Excludes(token Token) bool
+}
+
+type Engine struct {
+	rules []Rule
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func NewEngine(cfg config.Rules) (*Engine, error) {
+	e := &Engine{
+		allow: addressesSet(cfg.Allow),
+		deny:  addressesSet(cfg.Deny),
+	}
+
+	e.addRange("marketCap", cfg.MarketCap, infoValue(func(t *apiclient.TokenInfo) decimal.Decimal { return t.MarketCap }))
+	e.addRange("fdv", cfg.FDV, infoValue(func(t *apiclient.TokenInfo) decimal.Decimal { return t.FDV }))
+	e.addRange("volume", cfg.Volume, infoValue(func(t *apiclient.TokenInfo) decimal.Decimal { return t.Volume }))
+	e.addRange("athDistance", cfg.ATHDistance, infoValue(func(t *apiclient.TokenInfo) decimal.Decimal { return t.ATHChangePercentage.Abs() }))
+
+	if cfg.GenesisDate.From != "" || cfg.GenesisDate.To != "" {
+		rule, err := newGenesisDateRule(cfg.GenesisDate)
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, rule)
+	}
+
+	e.addRange("drawdown", cfg.Drawdown, metricsValue(func(m *PriceMetrics) decimal.Decimal { return m.Drawdown }))
+	e.addRange("trend30d", cfg.Trend30d, metricsValue(func(m *PriceMetrics) decimal.Decimal { return m.Trend30d }))
+	e.addRange("trend90d", cfg.Trend90d, metricsValue(func(m *PriceMetrics) decimal.Decimal { return m.Trend90d }))
+	e.addRange("decayScore", cfg.DecayScore, metricsValue(func(m *PriceMetrics) decimal.Decimal { return m.DecayScore }))
+
+	return e, nil
+}
+
+// Screen returns the name of the rule excluding the token or an empty string when the token passes all rules.
+// Price metrics rules are only applied when token.Metrics is set, so that the price chart can be fetched
+// only for tokens passing the other rules.
+func (e *Engine) Screen(token Token) string {
+	if rule, ok := e.Listed(token.Address); ok {
+		return rule
+	}
+
+	for _, rule := range e.rules {
+		if rule.Excludes(token) {
+			return rule.Name()
+		}
+	}
+	return ""
+}
+
+// Listed screens the token by its contract address alone, so that it also applies to tokens without metadata.
+// It returns "deny" for denied tokens and an empty string for allowed ones, ok being false for tokens on neither list.
+func (e *Engine) Listed(address string) (rule string, ok bool) {
+	address = strings.ToLower(address)
+	if e.deny[address] {
+		return "deny", true
+	}
+	if e.allow[address] {
+		return "", true
+	}
+	return "", false
+}
+
+func (e *Engine) addRange(name string, r config.Range, value func(token Token) (decimal.Decimal, bool)) {
+	if r.Min == nil && r.Max == nil {
+		return
+	}
+	rule := &rangeRule{
+		name:  name,
+		value: value,
+	}
+	if r.Min != nil {
+		min := decimal.NewFromFloat(*r.Min)
+		rule.min = &min
+	}
+	if r.Max != nil {
+		max := decimal.NewFromFloat(*r.Max)
+		rule.max = &max
+	}
+	e.rules = append(e.rules, rule)
+}
+
+type rangeRule struct {
+	name     string
+	min, max *decimal.Decimal
+	value    func(token Token) (decimal.Decimal, bool)
+}
+
+// infoValue reads a value from token metadata, zero meaning the value is unknown.
+func infoValue(value func(t *apiclient.TokenInfo) decimal.Decimal) func(token Token) (decimal.Decimal, bool) {
+	return func(token Token) (decimal.Decimal, bool) {
+		if token.Info == nil {
+			return decimal.Decimal{}, false
+		}
+		v := value(token.Info)
+		return v, !v.IsZero()
+	}
+}
+
+func metricsValue(value func(m *PriceMetrics) decimal.Decimal) func(token Token) (decimal.Decimal, bool) {
+	return func(token Token) (decimal.Decimal, bool) {
+		if token.Metrics == nil {
+			return decimal.Decimal{}, false
+		}
+		return value(token.Metrics), true
+	}
+}
+
+func (r *rangeRule) Name() string {
+	return r.name
+}
+
+func (r *rangeRule) Excludes(token Token) bool {
+	value, ok := r.value(token)
+	if !ok {
+		return false
+	}
+	if r.min != nil && value.LessThan(*r.min) {
+		return true
+	}
+	if r.max != nil && value.GreaterThan(*r.max) {
+		return true
+	}
+	return false
+}
+
+type genesisDateRule struct {
+	from, to *time.Time
+}
+
+func newGenesisDateRule(r config.DateRange) (*genesisDateRule, error) {
+	rule := &genesisDateRule{}
+	if r.From != "" {
+		from, err := time.Parse(dateFormat, r.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis date from %s: %w", r.From, err)
+		}
+		rule.from = &from
+	}
+	if r.To != "" {
+		to, err := time.Parse(dateFormat, r.To)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis date to %s: %w", r.To, err)
+		}
+		rule.to = &to
+	}
+	return rule, nil
+}
+
+func (r *genesisDateRule) Name() string {
+	return "genesisDate"
+}
+
+func (r *genesisDateRule) Excludes(token Token) bool {
+	if token.Info == nil || token.Info.GenesisDate == "" {
+		return false
+	}
+	genesisDate, err := time.Parse(dateFormat, token.Info.GenesisDate)
+	if err != nil {
+		return false
+	}
+	if r.from != nil && genesisDate.Before(*r.from) {
+		return true
+	}
+	if r.to != nil && genesisDate.After(*r.to) {
+		return true
+	}
+	return false
+}
+
+func addressesSet(addresses []string) map[string]bool {
+	set := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		set[strings.ToLower(address)] = true
+	}
+	return set
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
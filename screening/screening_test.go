@@ -0,0 +1,122 @@
+package screening
+
+import (
+	apiclient "aper/api-client"
+	"aper/config"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestEngineScreen(t *testing.T) {
+	info := func(marketCap, fdv, volume, athChange int64, genesisDate string) *apiclient.TokenInfo {
+		return &apiclient.TokenInfo{
+			ID:                  "token",
+			MarketCap:           decimal.NewFromInt(marketCap),
+			FDV:                 decimal.NewFromInt(fdv),
+			Volume:              decimal.NewFromInt(volume),
+			ATHChangePercentage: decimal.NewFromInt(athChange),
+			GenesisDate:         genesisDate,
+		}
+	}
+	rules := config.Rules{
+		MarketCap:   config.Range{Min: float64Ptr(1000), Max: float64Ptr(1000000)},
+		FDV:         config.Range{Max: float64Ptr(5000000)},
+		Volume:      config.Range{Min: float64Ptr(100)},
+		ATHDistance: config.Range{Max: float64Ptr(90)},
+		GenesisDate: config.DateRange{From: "2022-04-01", To: "2023-12-31"},
+		Drawdown:    config.Range{Max: float64Ptr(80)},
+		DecayScore:  config.Range{Max: float64Ptr(0.9)},
+		Allow:       []string{"0xAAAA", "0xAAAA2"},
+		Deny:        []string{"0xDDDD", "0xAAAA2"},
+	}
+	passing := info(500000, 1000000, 10000, -50, "2023-01-15")
+
+	tests := []struct {
+		desc     string
+		address  string
+		info     *apiclient.TokenInfo
+		metrics  *PriceMetrics
+		wantRule string
+	}{
+		{desc: "passing all rules", address: "0x1", info: passing},
+		{desc: "market cap above max", address: "0x1", info: info(2000000, 1000000, 10000, -50, "2023-01-15"), wantRule: "marketCap"},
+		{desc: "market cap below min", address: "0x1", info: info(500, 1000000, 10000, -50, "2023-01-15"), wantRule: "marketCap"},
+		{desc: "fdv above max", address: "0x1", info: info(500000, 6000000, 10000, -50, "2023-01-15"), wantRule: "fdv"},
+		{desc: "volume below min", address: "0x1", info: info(500000, 1000000, 50, -50, "2023-01-15"), wantRule: "volume"},
+		{desc: "too far below ath", address: "0x1", info: info(500000, 1000000, 10000, -95, "2023-01-15"), wantRule: "athDistance"},
+		{desc: "genesis date before from", address: "0x1", info: info(500000, 1000000, 10000, -50, "2021-01-01"), wantRule: "genesisDate"},
+		{desc: "genesis date after to", address: "0x1", info: info(500000, 1000000, 10000, -50, "2024-02-01"), wantRule: "genesisDate"},
+		{desc: "zero values are unknown", address: "0x1", info: info(0, 0, 0, 0, "")},
+		{desc: "unparsable genesis date is unknown", address: "0x1", info: info(500000, 1000000, 10000, -50, "15/01/2023")},
+		{desc: "no token info", address: "0x1"},
+		{desc: "drawdown above max", address: "0x1", info: passing, metrics: &PriceMetrics{Drawdown: decimal.NewFromInt(85)}, wantRule: "drawdown"},
+		{desc: "decay score above max", address: "0x1", info: passing,
+			metrics: &PriceMetrics{Drawdown: decimal.NewFromInt(50), DecayScore: decimal.NewFromFloat(0.95)}, wantRule: "decayScore"},
+		{desc: "metrics passing", address: "0x1", info: passing, metrics: &PriceMetrics{Drawdown: decimal.NewFromInt(50)}},
+		{desc: "allowed despite rules", address: "0xaaaa", info: info(2000000, 6000000, 50, -95, "2021-01-01")},
+		{desc: "denied despite passing", address: "0xdddd", info: passing, wantRule: "deny"},
+		{desc: "deny takes precedence over allow", address: "0xaaaa2", info: passing, wantRule: "deny"},
+	}
+
+	engine, err := NewEngine(rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			token := Token{Chain: apiclient.ETH, Address: tc.address, Info: tc.info, Metrics: tc.metrics}
+			if got := engine.Screen(token); got != tc.wantRule {
+				t.Errorf("rule: got %q, want %q", got, tc.wantRule)
+			}
+		})
+	}
+}
+
+func TestNewEngineInvalidGenesisDate(t *testing.T) {
+	tests := []struct {
+		desc  string
+		dates config.DateRange
+	}{
+		{desc: "invalid from", dates: config.DateRange{From: "2022-13-01"}},
+		{desc: "invalid to", dates: config.DateRange{To: "01/01/2023"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := NewEngine(config.Rules{GenesisDate: tc.dates}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestEngineListed(t *testing.T) {
+	engine, err := NewEngine(config.Rules{Allow: []string{"0xAAAA", "0xAAAA2"}, Deny: []string{"0xDDDD", "0xAAAA2"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		desc       string
+		address    string
+		wantRule   string
+		wantListed bool
+	}{
+		{desc: "allowlisted token without coingecko entry", address: "0xaaaa", wantListed: true},
+		{desc: "denied token", address: "0xDdDd", wantRule: "deny", wantListed: true},
+		{desc: "deny takes precedence over allow", address: "0xaaaa2", wantRule: "deny", wantListed: true},
+		{desc: "token on neither list", address: "0x1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			rule, listed := engine.Listed(tc.address)
+			if rule != tc.wantRule || listed != tc.wantListed {
+				t.Errorf("got rule %q, listed %v, want %q, %v", rule, listed, tc.wantRule, tc.wantListed)
+			}
+			// without token info, Screen keeps the token unless it is denied
+			if got := engine.Screen(Token{Chain: apiclient.ETH, Address: tc.address}); got != tc.wantRule {
+				t.Errorf("screen: got %q, want %q", got, tc.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type RunSummary struct {
+	ID              string
+	Command         string
+	TokenSymbol     string
+	TokenChain      string
+	StartedAt       time.Time
+	FinishedAt      *time.Time
+	Interrupted     bool
+	Holders         int // as retrieved
+	AnalysedHolders int
+	FoundTokens     int
+}
+
+// Runs returns recorded runs, the most recent first.
+func (s *Store) Runs() ([]RunSummary, error) {
+	rows, err := s.db.Query(`
+		SELECT r.run_id, r.command, r.token_symbol, r.token_chain, r.started_at, r.finished_at, r.interrupted,
+			(SELECT COUNT(*) FROM holders h WHERE h.run_id = r.run_id),
+			r.analysed_holders,
+			(SELECT COUNT(*) FROM tokens t WHERE t.run_id = r.run_id AND t.rule = '')
+		FROM runs r
+		ORDER BY r.started_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var (
+			run        RunSummary
+			startedAt  string
+			finishedAt sql.NullString
+		)
+		err := rows.Scan(&run.ID, &run.Command, &run.TokenSymbol, &run.TokenChain, &startedAt, &finishedAt,
+			&run.Interrupted, &run.Holders, &run.AnalysedHolders, &run.FoundTokens)
+		if err != nil {
+			return nil, err
+		}
+		if run.StartedAt, err = time.Parse(timeFormat, startedAt); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			t, err := time.Parse(timeFormat, finishedAt.String)
+			if err != nil {
+				return nil, err
+			}
+			run.FinishedAt = &t
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// CommonToken is a found token held by holders of several analysed tokens.
+type CommonToken struct {
+	Chain          string
+	Address        string
+	Symbol         string
+	AnalysedTokens int    // distinct analysed tokens the token was found among holders of
+	Symbols        string // comma separated symbols of these analysed tokens
+	Runs           int
+	MaxHolders     int     // in a single run
+	MaxValue       float64 // in a single run
+}
+
+// CommonTokens returns found tokens held by holders of at least minTokens different analysed tokens
+// in runs started since the given time, the most common first.
+func (s *Store) CommonTokens(since time.Time, minTokens int) ([]CommonToken, error) {
+	rows, err := s.db.Query(`
+		SELECT t.chain, t.address, MAX(t.symbol),
+			COUNT(DISTINCT r.token_chain || ':' || r.token_address) AS analysed_tokens,
+			GROUP_CONCAT(DISTINCT r.token_symbol),
+			COUNT(DISTINCT r.run_id), MAX(t.holders), MAX(t.value)
+		FROM tokens t
+		JOIN runs r ON r.run_id = t.run_id
+		WHERE t.rule = '' AND r.started_at >= ?
+		GROUP BY t.chain, t.address
+		HAVING analysed_tokens >= ?
+		ORDER BY analysed_tokens DESC, MAX(t.holders) DESC`,
+		since.UTC().Format(timeFormat), minTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []CommonToken
+	for rows.Next() {
+		var t CommonToken
+		if err := rows.Scan(&t.Chain, &t.Address, &t.Symbol, &t.AnalysedTokens, &t.Symbols, &t.Runs, &t.MaxHolders, &t.MaxValue); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// CommonWhale is a whale found among holders of several analysed tokens.
+type CommonWhale struct {
+	Address           string
+	AnalysedTokens    int
+	Symbols           string
+	Runs              int
+	MaxPortfolioValue float64
+}
+
+// CommonWhales returns whales found among holders of at least minTokens different analysed tokens
+// in runs started since the given time, the most common first.
+func (s *Store) CommonWhales(since time.Time, minTokens int) ([]CommonWhale, error) {
+	rows, err := s.db.Query(`
+		SELECT w.address,
+			COUNT(DISTINCT r.token_chain || ':' || r.token_address) AS analysed_tokens,
+			GROUP_CONCAT(DISTINCT r.token_symbol),
+			COUNT(DISTINCT r.run_id), MAX(w.portfolio_value)
+		FROM whales w
+		JOIN runs r ON r.run_id = w.run_id
+		WHERE r.started_at >= ?
+		GROUP BY w.address
+		HAVING analysed_tokens >= ?
+		ORDER BY analysed_tokens DESC, MAX(w.portfolio_value) DESC`,
+		since.UTC().Format(timeFormat), minTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var whales []CommonWhale
+	for rows.Next() {
+		var w CommonWhale
+		if err := rows.Scan(&w.Address, &w.AnalysedTokens, &w.Symbols, &w.Runs, &w.MaxPortfolioValue); err != nil {
+			return nil, err
+		}
+		whales = append(whales, w)
+	}
+	return whales, rows.Err()
+}
+
+// RunResults are the holders, whales and found tokens of a run.
+type RunResults struct {
+	TokenAddress string // lowercase address of the analysed token
+	TokenChain   string
+	Holders      map[string]float64 // holder address to portfolio value, zero when the holder's balances were not retrieved
+	Whales       map[string]float64 // whale address to portfolio value
+	Tokens       []FoundToken
+}
+
+type FoundToken struct {
+	Chain   string
+	Address string
+	Symbol  string
+	Value   float64
+	Holders int
+}
+
+// RunResults returns results recorded for the run. Holders are all holders of the token as retrieved,
+// including the ones not analysed for holding too few tokens.
+func (s *Store) RunResults(runID string) (*RunResults, error) {
+	results := &RunResults{
+		Holders: make(map[string]float64),
+		Whales:  make(map[string]float64),
+	}
+	err := s.db.QueryRow(`SELECT token_address, token_chain FROM runs WHERE run_id = ?`, runID).
+		Scan(&results.TokenAddress, &results.TokenChain)
+	if err == sql.ErrNoRows {
+		return nil, errors.Errorf("run %s not found", runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.addressValues(results.Holders, `
+		SELECT h.address, COALESCE(SUM(b.quote), 0)
+		FROM holders h
+		LEFT JOIN balances b ON b.run_id = h.run_id AND b.holder = h.address
+		WHERE h.run_id = ?
+		GROUP BY h.address`, runID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addressValues(results.Whales, `SELECT address, portfolio_value FROM whales WHERE run_id = ?`, runID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT chain, address, symbol, value, holders FROM tokens WHERE run_id = ? AND rule = ''`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t FoundToken
+		if err := rows.Scan(&t.Chain, &t.Address, &t.Symbol, &t.Value, &t.Holders); err != nil {
+			return nil, err
+		}
+		results.Tokens = append(results.Tokens, t)
+	}
+	return results, rows.Err()
+}
+
+func (s *Store) addressValues(values map[string]float64, query string, args ...interface{}) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			address string
+			value   float64
+		)
+		if err := rows.Scan(&address, &value); err != nil {
+			return err
+		}
+		values[address] = value
+	}
+	return rows.Err()
+}
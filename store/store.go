@@ -0,0 +1,263 @@
+// Package store records runs, holders, their balances and found tokens in a SQLite database,
+// so that results of many runs can be queried together.
+package store
+
+import (
+	"aper/analysis"
+	"aper/config"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// timeFormat keeps times sortable as text.
+const timeFormat = time.RFC3339
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	run_id           TEXT PRIMARY KEY,
+	command          TEXT NOT NULL,
+	token_address    TEXT NOT NULL,
+	token_chain      TEXT NOT NULL,
+	token_symbol     TEXT NOT NULL,
+	params           TEXT NOT NULL, -- JSON object of flags
+	blocks           TEXT NOT NULL, -- JSON object of chain to block height
+	rules            TEXT NOT NULL, -- JSON screening rules
+	started_at       TEXT NOT NULL,
+	finished_at      TEXT,
+	interrupted      INTEGER NOT NULL DEFAULT 0,
+	analysed_holders INTEGER NOT NULL DEFAULT 0
+);
+
+-- holders of the analysed token as retrieved, including those holding too few tokens to be analysed
+CREATE TABLE IF NOT EXISTS holders (
+	run_id   TEXT NOT NULL REFERENCES runs (run_id),
+	address  TEXT NOT NULL,
+	balance  TEXT NOT NULL,
+	decimals INTEGER NOT NULL,
+	PRIMARY KEY (run_id, address)
+);
+
+CREATE TABLE IF NOT EXISTS balances (
+	run_id           TEXT NOT NULL REFERENCES runs (run_id),
+	holder           TEXT NOT NULL,
+	chain            TEXT NOT NULL,
+	contract_address TEXT NOT NULL,
+	symbol           TEXT NOT NULL,
+	balance          TEXT NOT NULL,
+	decimals         INTEGER NOT NULL,
+	quote            REAL NOT NULL,
+	type             TEXT NOT NULL,
+	PRIMARY KEY (run_id, holder, chain, contract_address)
+);
+
+-- balances worth at least the min holding value, rule is empty for tokens passing the screening rules
+CREATE TABLE IF NOT EXISTS holdings (
+	run_id           TEXT NOT NULL REFERENCES runs (run_id),
+	holder           TEXT NOT NULL,
+	chain            TEXT NOT NULL,
+	contract_address TEXT NOT NULL,
+	symbol           TEXT NOT NULL,
+	quantity         TEXT NOT NULL,
+	value            REAL NOT NULL,
+	rule             TEXT NOT NULL,
+	PRIMARY KEY (run_id, holder, chain, contract_address)
+);
+
+-- holdings aggregated across holders, rule is empty for found tokens
+CREATE TABLE IF NOT EXISTS tokens (
+	run_id        TEXT NOT NULL REFERENCES runs (run_id),
+	chain         TEXT NOT NULL,
+	address       TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	coingecko_id  TEXT NOT NULL,
+	quantity      TEXT NOT NULL,
+	value         REAL NOT NULL,
+	holders       INTEGER NOT NULL,
+	holders_share REAL NOT NULL,
+	rule          TEXT NOT NULL,
+	PRIMARY KEY (run_id, chain, address)
+);
+
+CREATE TABLE IF NOT EXISTS whales (
+	run_id          TEXT NOT NULL REFERENCES runs (run_id),
+	address         TEXT NOT NULL,
+	portfolio_value REAL NOT NULL,
+	PRIMARY KEY (run_id, address)
+);
+`
+
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it and its tables when needed.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// holders are recorded by concurrent workers, a single connection serializes writes
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;"); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failure configuring database")
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failure creating tables")
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Run is the parameters a run was started with.
+type Run struct {
+	ID           string
+	Command      string
+	TokenAddress string
+	TokenChain   string
+	TokenSymbol  string
+	Params       map[string]string // flags
+	Blocks       map[string]int    // chain to block height
+	Rules        config.Rules
+	StartedAt    time.Time
+}
+
+// StartRun records the run. A resumed run keeps the time it was first started at.
+func (s *Store) StartRun(run Run) error {
+	params, err := json.Marshal(run.Params)
+	if err != nil {
+		return err
+	}
+	blocks, err := json.Marshal(run.Blocks)
+	if err != nil {
+		return err
+	}
+	rules, err := json.Marshal(run.Rules)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO runs (run_id, command, token_address, token_chain, token_symbol, params, blocks, rules, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (run_id) DO UPDATE SET
+			params = excluded.params, blocks = excluded.blocks, rules = excluded.rules, finished_at = NULL`,
+		run.ID, run.Command, strings.ToLower(run.TokenAddress), run.TokenChain, run.TokenSymbol,
+		string(params), string(blocks), string(rules), run.StartedAt.UTC().Format(timeFormat))
+	return errors.Wrapf(err, "failure recording run %s", run.ID)
+}
+
+// FinishRun records when the run finished and how many holders were analysed.
+func (s *Store) FinishRun(runID string, finishedAt time.Time, interrupted bool, analysedHolders int) error {
+	_, err := s.db.Exec(`UPDATE runs SET finished_at = ?, interrupted = ?, analysed_holders = ? WHERE run_id = ?`,
+		finishedAt.UTC().Format(timeFormat), interrupted, analysedHolders, runID)
+	return errors.Wrapf(err, "failure recording end of run %s", runID)
+}
+
+// AddHolders records holders of the analysed token.
+func (s *Store) AddHolders(runID string, holders []class_a.Portfolio) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO holders (run_id, address, balance, decimals) VALUES (?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, holder := range holders {
+			if _, err := stmt.Exec(runID, strings.ToLower(holder.Address), holder.Balance, holder.ContractDecimals); err != nil {
+				return errors.Wrapf(err, "failure recording holder %s", holder.Address)
+			}
+		}
+		return nil
+	})
+}
+
+// AddHolderResult records the holder's balances and screened holdings on every chain.
+func (s *Store) AddHolderResult(runID string, result *analysis.HolderResult) error {
+	holder := strings.ToLower(result.Address)
+	return s.inTx(func(tx *sql.Tx) error {
+		for chain, chainResult := range result.Chains {
+			for _, balance := range chainResult.Balances {
+				_, err := tx.Exec(`
+					INSERT OR REPLACE INTO balances (run_id, holder, chain, contract_address, symbol, balance, decimals, quote, type)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					runID, holder, chain, strings.ToLower(balance.ContractAddress), balance.ContractTickerSymbol,
+					balance.Balance, balance.ContractDecimals, balance.Quote, balance.Type)
+				if err != nil {
+					return errors.Wrapf(err, "failure recording balance of %s", balance.ContractTickerSymbol)
+				}
+			}
+			for _, h := range chainResult.Holdings {
+				_, err := tx.Exec(`
+					INSERT OR REPLACE INTO holdings (run_id, holder, chain, contract_address, symbol, quantity, value, rule)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+					runID, holder, chain, h.ContractAddress, h.Symbol, h.Quantity.Units().String(), h.Value.InexactFloat64(), h.Rule)
+				if err != nil {
+					return errors.Wrapf(err, "failure recording holding of %s", h.Symbol)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AddResult records found and skipped tokens and whales of the run, replacing ones recorded before.
+func (s *Store) AddResult(runID string, result *analysis.Result) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM tokens WHERE run_id = ?`, runID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM whales WHERE run_id = ?`, runID); err != nil {
+			return err
+		}
+
+		for _, tokens := range []map[string][]analysis.Token{result.Tokens, result.SkippedTokens} {
+			for _, chainTokens := range tokens {
+				for _, token := range chainTokens {
+					var coingeckoID string
+					if token.Info != nil {
+						coingeckoID = token.Info.ID
+					}
+					_, err := tx.Exec(`
+						INSERT INTO tokens (run_id, chain, address, symbol, coingecko_id, quantity, value, holders, holders_share, rule)
+						VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+						runID, token.Chain, token.Address, token.Symbol, coingeckoID, token.Quantity.Units().String(),
+						token.Value.InexactFloat64(), token.HolderMetrics.Holders, token.HolderMetrics.HoldersShare, token.Rule)
+					if err != nil {
+						return errors.Wrapf(err, "failure recording token %s", token.Symbol)
+					}
+				}
+			}
+		}
+
+		for _, whale := range result.Whales {
+			_, err := tx.Exec(`INSERT INTO whales (run_id, address, portfolio_value) VALUES (?, ?, ?)`,
+				runID, strings.ToLower(whale.Address), whale.PortfolioValue.InexactFloat64())
+			if err != nil {
+				return errors.Wrapf(err, "failure recording whale %s", whale.Address)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"aper/analysis"
+	apiclient "aper/api-client"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cshields143/govalent/class_a"
+	"github.com/shopspring/decimal"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "aper.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+// recordRun records a finished run of the token whose holders hold the found tokens and include the whales.
+func recordRun(t *testing.T, s *Store, runID, tokenAddress, symbol string, startedAt time.Time, found []string, whales []string) {
+	t.Helper()
+	err := s.StartRun(Run{
+		ID:           runID,
+		Command:      "balancesOfTokensHolders",
+		TokenAddress: tokenAddress,
+		TokenChain:   "ETHEREUM",
+		TokenSymbol:  symbol,
+		StartedAt:    startedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddHolders(runID, []class_a.Portfolio{{Address: "0xA", Balance: "1000", ContractDecimals: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddHolderResult(runID, &analysis.HolderResult{
+		Address: "0xA",
+		Chains: map[string]*analysis.ChainResult{
+			"ETHEREUM": {
+				PortfolioValue: decimal.NewFromInt(500),
+				Balances:       []class_a.Portfolio{{ContractAddress: "0xF", ContractTickerSymbol: "F", Balance: "5", Quote: 500}},
+				Holdings: []analysis.HoldingResult{
+					{ContractAddress: "0xf", Symbol: "F", Quantity: mustTokenAmount(t, "5"), Value: decimal.NewFromInt(500)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &analysis.Result{
+		TokenSymbol:     symbol,
+		AnalysedHolders: 1,
+		Tokens:          map[string][]analysis.Token{},
+	}
+	for _, address := range found {
+		result.Tokens["ETHEREUM"] = append(result.Tokens["ETHEREUM"], analysis.Token{
+			Symbol:        address,
+			Address:       address,
+			Chain:         "ETHEREUM",
+			Quantity:      mustTokenAmount(t, "5"),
+			Value:         decimal.NewFromInt(500),
+			HolderMetrics: analysis.HolderMetrics{Holders: 1, HoldersShare: 100},
+		})
+	}
+	for _, address := range whales {
+		result.Whales = append(result.Whales, analysis.Whale{Address: address, PortfolioValue: decimal.NewFromInt(200000)})
+	}
+	if err := s.AddResult(runID, result); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishRun(runID, startedAt.Add(time.Minute), false, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustTokenAmount(t *testing.T, raw string) apiclient.TokenAmount {
+	t.Helper()
+	amount, err := apiclient.NewTokenAmount(raw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func TestRuns(t *testing.T) {
+	s := openTestStore(t)
+	startedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	recordRun(t, s, "A_1", "0x1", "A", startedAt, []string{"0xf", "0xg"}, nil)
+	recordRun(t, s, "B_1", "0x2", "B", startedAt.Add(time.Hour), []string{"0xf"}, nil)
+
+	runs, err := s.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(runs))
+	}
+	if runs[0].ID != "B_1" || runs[1].ID != "A_1" {
+		t.Errorf("got runs %s, %s, want the most recent first", runs[0].ID, runs[1].ID)
+	}
+	if runs[1].Holders != 1 || runs[1].AnalysedHolders != 1 || runs[1].FoundTokens != 2 || runs[1].FinishedAt == nil {
+		t.Errorf("got run %+v", runs[1])
+	}
+}
+
+func TestCommonTokensAndWhales(t *testing.T) {
+	s := openTestStore(t)
+	june := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	recordRun(t, s, "A_1", "0x1", "A", june, []string{"0xf", "0xg"}, []string{"0xw1", "0xw2"})
+	recordRun(t, s, "A_2", "0x1", "A", june.Add(24*time.Hour), []string{"0xg"}, []string{"0xw2"})
+	recordRun(t, s, "B_1", "0x2", "B", june.Add(48*time.Hour), []string{"0xf"}, []string{"0xw1"})
+	recordRun(t, s, "C_1", "0x3", "C", june.Add(-30*24*time.Hour), []string{"0xg"}, []string{"0xw2"})
+
+	tokens, err := s.CommonTokens(june, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Address != "0xf" || tokens[0].AnalysedTokens != 2 || tokens[0].Runs != 2 {
+		t.Errorf("got common tokens %+v, want 0xf found for 2 tokens in 2 runs", tokens)
+	}
+
+	tokens, err = s.CommonTokens(time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("got %d common tokens of all runs, want 2", len(tokens))
+	}
+
+	whales, err := s.CommonWhales(june, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(whales) != 1 || whales[0].Address != "0xw1" || whales[0].Runs != 2 {
+		t.Errorf("got common whales %+v, want 0xw1 in 2 runs", whales)
+	}
+}
+
+func TestStartRunKeepsStartOfResumedRun(t *testing.T) {
+	s := openTestStore(t)
+	startedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	recordRun(t, s, "A_1", "0x1", "A", startedAt, []string{"0xf"}, nil)
+
+	err := s.StartRun(Run{ID: "A_1", Command: "balancesOfTokensHolders", TokenAddress: "0x1", TokenChain: "ETHEREUM",
+		TokenSymbol: "A", StartedAt: startedAt.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := s.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || !runs[0].StartedAt.Equal(startedAt) || runs[0].FinishedAt != nil {
+		t.Errorf("got run %+v, want it started at %s and not finished", runs[0], startedAt)
+	}
+}
+
+func TestRunResults(t *testing.T) {
+	s := openTestStore(t)
+	recordRun(t, s, "A_1", "0xAB", "A", time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC), []string{"0xf"}, []string{"0xW"})
+	// a holder whose balances were not retrieved
+	if err := s.AddHolders("A_1", []class_a.Portfolio{{Address: "0xB", Balance: "10", ContractDecimals: 0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := s.RunResults("A_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.TokenAddress != "0xab" || results.TokenChain != "ETHEREUM" {
+		t.Errorf("got token %s on %s, want 0xab on ETHEREUM", results.TokenAddress, results.TokenChain)
+	}
+	if len(results.Holders) != 2 || results.Holders["0xa"] != 500 || results.Holders["0xb"] != 0 {
+		t.Errorf("got holders %v, want 0xa worth 500 and 0xb worth 0", results.Holders)
+	}
+	if len(results.Whales) != 1 || results.Whales["0xw"] != 200000 {
+		t.Errorf("got whales %v, want 0xw worth 200000", results.Whales)
+	}
+	if len(results.Tokens) != 1 || results.Tokens[0].Address != "0xf" || results.Tokens[0].Holders != 1 {
+		t.Errorf("got tokens %+v, want 0xf held by 1 holder", results.Tokens)
+	}
+
+	if _, err := s.RunResults("B_1"); err == nil {
+		t.Error("got results of a run not recorded")
+	}
+}